@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+const (
+	SEARCH_BANDIT   = "bandit"   // one arm per round out of a fixed set, exploiting the best one
+	BANDIT_UCB1     = "ucb1"     // highest mean plus a confidence bonus
+	BANDIT_THOMPSON = "thompson" // highest draw from a normal posterior of every arm
+	// uncounted pulls in a row after which an arm is left out, until every arm is
+	BANDIT_MAX_FAILURES = 3
+)
+
+/*
+BanditConfig is the bandit mode for continuous operation. Every arm sets some knobs by
+name, the others keep the value they have when the tuner starts; without Arms the
+current configuration and its neighbours on every knob are the arms.
+*/
+type BanditConfig struct {
+	Policy      string
+	Arms        []map[string]string
+	Exploration float64 // UCB1 bonus weight, in units of the spread of the arm means
+	Penalty     float64 // an infeasible pull costs this much more, relative to its cost
+}
+
+type arm struct {
+	point    []int
+	pulls    int
+	sum      float64 // of the rewards
+	rewards  []float64
+	failures int // uncounted pulls in a row
+}
+
+func (a *arm) mean() float64 {
+	return a.sum / float64(a.pulls)
+}
+
+/*
+bandit keeps the statistics of every arm across rounds. The reward of a pull is minus
+the objective cost, made worse by Penalty when the constraints are not met.
+*/
+type bandit struct {
+	space   []Dimension
+	arms    []*arm
+	applied []int
+	pulls   int
+	rng     *rand.Rand
+}
+
+func newBandit(config Config, space []Dimension) *bandit {
+	b := &bandit{space: space, applied: make([]int, len(space))}
+	seed := config.Search.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	b.rng = rand.New(rand.NewSource(seed))
+	current := make([]int, len(space))
+	for i, d := range space {
+		b.applied[i] = d.Current
+		current[i] = d.nearest()
+	}
+
+	add := func(point []int) {
+		if allowedPoint(config, space, point) && !slices.ContainsFunc(b.arms, func(a *arm) bool { return slices.Equal(a.point, point) }) {
+			b.arms = append(b.arms, &arm{point: point})
+		}
+	}
+	for _, values := range config.Bandit.Arms {
+		point := slices.Clone(current)
+		for name, value := range values {
+			i := slices.IndexFunc(space, func(d Dimension) bool { return d.Name == name })
+			if i < 0 {
+				panic(fmt.Sprintf("bandit arm sets unknown knob %s", name))
+			}
+			if point[i] = slices.Index(space[i].Values, value); point[i] < 0 {
+				panic(fmt.Sprintf("bandit arm sets %s to %s, not among %v", name, value, space[i].Values))
+			}
+		}
+		add(point)
+	}
+	if len(config.Bandit.Arms) == 0 {
+		add(slices.Clone(current))
+		for i, d := range space {
+			for _, delta := range []int{-1, 1} {
+				if index := current[i] + delta; index >= 0 && index < len(d.Values) {
+					point := slices.Clone(current)
+					point[i] = index
+					add(point)
+				}
+			}
+		}
+	}
+	if len(b.arms) == 0 {
+		panic("no bandit arm within the allowed ranges")
+	}
+	fmt.Printf("Bandit over %d arms with %s\n", len(b.arms), config.Bandit.Policy)
+	return b
+}
+
+func (b *bandit) reward(config Config, s Sample) float64 {
+	cost := objective.Cost(s)
+	if feasible(config, s) {
+		return -cost
+	}
+	return -cost - config.Bandit.Penalty*math.Abs(cost)
+}
+
+// spread returns the range of the arm means, the scale of exploration
+func (b *bandit) spread() float64 {
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, a := range b.arms {
+		if a.pulls > 0 {
+			low, high = min(low, a.mean()), max(high, a.mean())
+		}
+	}
+	return math.Max(high-low, 1e-9)
+}
+
+/*
+choose returns the arm to pull, every arm is pulled once before the policy decides.
+Arms whose last BANDIT_MAX_FAILURES pulls were not counted are left out, otherwise one
+that never gets a valid sample would be chosen forever.
+*/
+func (b *bandit) choose(config Config) *arm {
+	usable := func(a *arm) bool { return a.failures < BANDIT_MAX_FAILURES }
+	if !slices.ContainsFunc(b.arms, usable) {
+		// nessun braccio misurabile, es. link senza traffico: si riprovano tutti
+		for _, a := range b.arms {
+			a.failures = 0
+		}
+	}
+	for _, a := range b.arms {
+		if usable(a) && a.pulls == 0 {
+			return a
+		}
+	}
+	spread := b.spread()
+	var chosen *arm
+	bestScore := math.Inf(-1)
+	for _, a := range b.arms {
+		if !usable(a) {
+			continue
+		}
+		var score float64
+		switch config.Bandit.Policy {
+		case BANDIT_UCB1:
+			score = a.mean() + config.Bandit.Exploration*spread*math.Sqrt(2*math.Log(float64(b.pulls))/float64(a.pulls))
+		case BANDIT_THOMPSON:
+			// con una sola tirata la varianza e' ignota, si usa la dispersione delle medie
+			sd := spread
+			if a.pulls > 1 {
+				sd = math.Sqrt(variance(a.rewards))
+			}
+			score = a.mean() + b.rng.NormFloat64()*sd/math.Sqrt(float64(a.pulls))
+		default:
+			panic(fmt.Sprintf("unknown bandit policy %q", config.Bandit.Policy))
+		}
+		if score > bestScore {
+			chosen, bestScore = a, score
+		}
+	}
+	return chosen
+}
+
+// best returns the arm with the highest mean reward
+func (b *bandit) best() *arm {
+	var best *arm
+	for _, a := range b.arms {
+		if a.pulls > 0 && (best == nil || a.mean() > best.mean()) {
+			best = a
+		}
+	}
+	return best
+}
+
+// regret returns the cumulative regret of the pulls so far against the best arm, with the current means
+func (b *bandit) regret() float64 {
+	best := b.best()
+	if best == nil {
+		return 0
+	}
+	var regret float64
+	for _, a := range b.arms {
+		if a.pulls > 0 {
+			regret += float64(a.pulls) * (best.mean() - a.mean())
+		}
+	}
+	return regret
+}
+
+/*
+pull sets the arm the policy chooses, measures it and updates its statistics. A sample
+that cannot be trusted is not counted.
+*/
+func (b *bandit) pull(ethHandle *ethtool.Ethtool, config Config, interval int) (Config, Sample) {
+	p := message.NewPrinter(language.English)
+	a := b.choose(config)
+	next, applied, err := applyPoint(ethHandle, config, b.space, b.applied, a.point)
+	config, b.applied = next, applied
+	if err != nil {
+		// un braccio che non si riesce a impostare non va piu' scelto
+		p.Printf("%s, removing arm %s\n", err, pointString(b.space, a.point))
+		b.arms = slices.DeleteFunc(b.arms, func(other *arm) bool { return other == a })
+		if len(b.arms) == 0 {
+			panic("no bandit arm left")
+		}
+		return config, measure(ethHandle, config, interval)
+	}
+
+	sample, _ := measureSamples(ethHandle, config, interval, config.Significance.SubWindows)
+	reward := b.reward(config, sample)
+	if sample.Invalid != "" || math.IsInf(reward, 0) || math.IsNaN(reward) {
+		a.failures++
+		p.Printf("Arm %s not counted, reward %f (%d/%d)\n", pointString(b.space, a.point), reward, a.failures, BANDIT_MAX_FAILURES)
+		return config, sample
+	}
+	a.failures = 0
+	a.pulls++
+	a.sum += reward
+	a.rewards = append(a.rewards, reward)
+	b.pulls++
+
+	best := b.best()
+	p.Printf("Arm %s: reward %f, mean %f over %d pulls\n", pointString(b.space, a.point), reward, a.mean(), a.pulls)
+	p.Printf("Best arm %s (mean %f), cumulative regret %f over %d pulls\n", pointString(b.space, best.point), best.mean(), b.regret(), b.pulls)
+	return config, sample
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// pulled returns an arm that got these rewards
+func pulled(rewards ...float64) *arm {
+	a := &arm{rewards: rewards, pulls: len(rewards)}
+	for _, r := range rewards {
+		a.sum += r
+	}
+	return a
+}
+
+// fakeBandit counts the pulls of the arms as the bandit would
+func fakeBandit(arms ...*arm) *bandit {
+	b := &bandit{arms: arms, rng: rand.New(rand.NewSource(1))}
+	for _, a := range arms {
+		b.pulls += a.pulls
+	}
+	return b
+}
+
+func TestBanditChoose(t *testing.T) {
+	ucb := func(exploration float64) Config {
+		return Config{Bandit: BanditConfig{Policy: BANDIT_UCB1, Exploration: exploration}}
+	}
+	thompson := Config{Bandit: BanditConfig{Policy: BANDIT_THOMPSON}}
+	failed := func(a *arm) *arm {
+		a.failures = BANDIT_MAX_FAILURES
+		return a
+	}
+	tests := []struct {
+		name   string
+		config Config
+		arms   []*arm
+		want   int
+	}{
+		{"unpulled first", ucb(1), []*arm{pulled(-1), {}, pulled(-2)}, 1},
+		{"greedy", ucb(0), []*arm{pulled(-3, -3), pulled(-1, -1), pulled(-2, -2)}, 1},
+		// tanta esplorazione favorisce il braccio tirato meno
+		{"explore", ucb(10), []*arm{pulled(-1, -1, -1, -1, -1, -1, -1, -1), pulled(-2)}, 1},
+		{"failed left out", ucb(0), []*arm{failed(pulled(-1)), pulled(-2)}, 1},
+		{"failed unpulled left out", ucb(0), []*arm{failed(&arm{}), pulled(-2)}, 1},
+		// tutti falliti: si riprovano tutti, prima quello mai contato
+		{"all failed", ucb(0), []*arm{failed(pulled(-1)), failed(&arm{})}, 1},
+		// senza varianza Thompson sceglie la media più alta
+		{"thompson", thompson, []*arm{pulled(-3, -3), pulled(-1, -1), pulled(-2, -2)}, 1},
+	}
+	for _, test := range tests {
+		b := fakeBandit(test.arms...)
+		if got := b.choose(test.config); got != test.arms[test.want] {
+			t.Errorf("%s: choose = arm %d, want arm %d", test.name, armIndex(test.arms, got), test.want)
+		}
+	}
+}
+
+func armIndex(arms []*arm, a *arm) int {
+	for i := range arms {
+		if arms[i] == a {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestBanditRegret(t *testing.T) {
+	tests := []struct {
+		name   string
+		arms   []*arm
+		best   int
+		regret float64
+	}{
+		{"no pulls", []*arm{{}, {}}, -1, 0},
+		{"only the best", []*arm{pulled(-1, -1), {}}, 0, 0},
+		// 2 tirate a 1 di distanza e 1 a 3
+		{"mixed", []*arm{pulled(-2, -2), pulled(-1, -1, -1), pulled(-4)}, 1, 2*1 + 1*3},
+	}
+	for _, test := range tests {
+		b := fakeBandit(test.arms...)
+		if got := armIndex(test.arms, b.best()); got != test.best {
+			t.Errorf("%s: best = arm %d, want arm %d", test.name, got, test.best)
+		}
+		if got := b.regret(); !near(got, test.regret, 1e-9) {
+			t.Errorf("%s: regret = %g, want %g", test.name, got, test.regret)
+		}
+	}
+}
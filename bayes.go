@@ -0,0 +1,334 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+)
+
+const (
+	SEARCH_BAYESIAN = "bayesian" // Gaussian process surrogate, expected improvement times probability of feasibility
+	MAX_CANDIDATES  = 4096       // spaces up to this size are scored whole, bigger ones are sampled
+)
+
+/*
+gaussianProcess is a GP regression with a squared exponential kernel over points scaled
+to [0, 1] on every dimension, the targets standardized so the prior variance is 1.
+*/
+type gaussianProcess struct {
+	xs          [][]float64
+	chol        [][]float64 // lower Cholesky factor of K + noise I
+	alpha       []float64   // (K + noise I)^-1 y
+	mean, scale float64     // of the targets
+	lengthScale float64
+}
+
+func (gp *gaussianProcess) kernel(a []float64, b []float64) float64 {
+	var d2 float64
+	for i := range a {
+		d2 += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return math.Exp(-d2 / (2 * gp.lengthScale * gp.lengthScale))
+}
+
+// fitGP conditions a GP on the observations, nil without any
+func fitGP(xs [][]float64, ys []float64, lengthScale float64, noise float64) *gaussianProcess {
+	if len(xs) == 0 {
+		return nil
+	}
+	gp := &gaussianProcess{xs: xs, lengthScale: lengthScale, mean: mean(ys), scale: 1}
+	if len(ys) > 1 {
+		if sd := math.Sqrt(variance(ys)); sd > 0 {
+			gp.scale = sd
+		}
+	}
+	n := len(xs)
+	k := make([][]float64, n)
+	for i := range n {
+		k[i] = make([]float64, n)
+		for j := range n {
+			k[i][j] = gp.kernel(xs[i], xs[j])
+		}
+		k[i][i] += noise
+	}
+	gp.chol = cholesky(k)
+	y := make([]float64, n)
+	for i := range n {
+		y[i] = (ys[i] - gp.mean) / gp.scale
+	}
+	gp.alpha = solveUpper(gp.chol, solveLower(gp.chol, y))
+	return gp
+}
+
+// predict returns the posterior mean and standard deviation at x
+func (gp *gaussianProcess) predict(x []float64) (float64, float64) {
+	kx := make([]float64, len(gp.xs))
+	for i, xi := range gp.xs {
+		kx[i] = gp.kernel(x, xi)
+	}
+	var mu float64
+	for i := range kx {
+		mu += kx[i] * gp.alpha[i]
+	}
+	v := solveLower(gp.chol, kx)
+	var explained float64
+	for _, vi := range v {
+		explained += vi * vi
+	}
+	sigma := math.Sqrt(math.Max(1-explained, 1e-12))
+	return gp.mean + mu*gp.scale, sigma * gp.scale
+}
+
+// cholesky returns the lower factor of a symmetric positive definite matrix
+func cholesky(a [][]float64) [][]float64 {
+	n := len(a)
+	l := make([][]float64, n)
+	for i := range n {
+		l[i] = make([]float64, n)
+		for j := 0; j <= i; j++ {
+			sum := a[i][j]
+			for k := range j {
+				sum -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				// il rumore sulla diagonale la tiene positiva, questo copre gli arrotondamenti
+				l[i][i] = math.Sqrt(math.Max(sum, 1e-12))
+			} else {
+				l[i][j] = sum / l[j][j]
+			}
+		}
+	}
+	return l
+}
+
+// solveLower solves l x = b by forward substitution
+func solveLower(l [][]float64, b []float64) []float64 {
+	x := make([]float64, len(b))
+	for i := range b {
+		sum := b[i]
+		for k := range i {
+			sum -= l[i][k] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	return x
+}
+
+// solveUpper solves l^T x = b by back substitution
+func solveUpper(l [][]float64, b []float64) []float64 {
+	x := make([]float64, len(b))
+	for i := len(b) - 1; i >= 0; i-- {
+		sum := b[i]
+		for k := i + 1; k < len(b); k++ {
+			sum -= l[k][i] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	return x
+}
+
+func normalCDF(z float64) float64 {
+	return 0.5 * math.Erfc(-z/math.Sqrt2)
+}
+
+func normalPDF(z float64) float64 {
+	return math.Exp(-z*z/2) / math.Sqrt(2*math.Pi)
+}
+
+// expectedImprovement of a cost below best, for a posterior N(mu, sigma)
+func expectedImprovement(mu float64, sigma float64, best float64) float64 {
+	z := (best - mu) / sigma
+	return (best-mu)*normalCDF(z) + sigma*normalPDF(z)
+}
+
+type observation struct {
+	point    []int
+	cost     float64
+	feasible bool
+}
+
+/*
+bayesianSearch measures InitialPoints configurations, the current one and random ones,
+then the candidate maximising the expected improvement of the objective times the
+probability of satisfying the constraints. Cost and feasibility are two GPs, the second
+regressing +1 for feasible and -1 for infeasible samples, so the loss constraint steers
+the search before any feasible configuration is known. The observations are kept
+across rounds and once every point was measured the predicted optimum is measured again.
+*/
+type bayesianSearch struct {
+	config       Config
+	space        []Dimension
+	rng          *rand.Rand
+	start        []int
+	observations []observation
+	cost         *gaussianProcess
+	feasibility  *gaussianProcess
+}
+
+func (b *bayesianSearch) Name() string { return SEARCH_BAYESIAN }
+
+// scale maps a point to [0, 1] on every dimension, booleans to 0 and 1
+func (b *bayesianSearch) scale(point []int) []float64 {
+	x := make([]float64, len(point))
+	for i, d := range b.space {
+		if len(d.Values) > 1 {
+			x[i] = float64(point[i]) / float64(len(d.Values)-1)
+		}
+	}
+	return x
+}
+
+func (b *bayesianSearch) observed(point []int) bool {
+	return slices.ContainsFunc(b.observations, func(o observation) bool { return slices.Equal(o.point, point) })
+}
+
+// candidates returns the allowed points not measured yet, all of them if the space is small
+func (b *bayesianSearch) candidates() [][]int {
+	size := 1
+	for _, d := range b.space {
+		size *= len(d.Values)
+		if size > MAX_CANDIDATES {
+			break
+		}
+	}
+
+	var points [][]int
+	add := func(point []int) {
+		if allowedPoint(b.config, b.space, point) && !b.observed(point) && !slices.ContainsFunc(points, func(p []int) bool { return slices.Equal(p, point) }) {
+			points = append(points, point)
+		}
+	}
+	if size <= MAX_CANDIDATES {
+		grid := &gridSearch{config: b.config, space: b.space}
+		for point := grid.Next(); point != nil; point = grid.Next() {
+			add(point)
+		}
+		return points
+	}
+	// spazio troppo grande: punti a caso piu' i vicini delle configurazioni migliori
+	for range MAX_CANDIDATES / 2 {
+		point := make([]int, len(b.space))
+		for i, d := range b.space {
+			point[i] = b.rng.Intn(len(d.Values))
+		}
+		add(point)
+	}
+	for _, o := range b.observations {
+		if !o.feasible {
+			continue
+		}
+		for i, d := range b.space {
+			for _, delta := range []int{-1, 1} {
+				if index := o.point[i] + delta; index >= 0 && index < len(d.Values) {
+					point := slices.Clone(o.point)
+					point[i] = index
+					add(point)
+				}
+			}
+		}
+	}
+	return points
+}
+
+// probabilityFeasible of a point under the feasibility GP, 1 before any observation
+func (b *bayesianSearch) probabilityFeasible(x []float64) float64 {
+	if b.feasibility == nil {
+		return 1
+	}
+	mu, sigma := b.feasibility.predict(x)
+	return normalCDF(mu / sigma)
+}
+
+func (b *bayesianSearch) Next() []int {
+	if len(b.observations) == 0 {
+		return slices.Clone(b.start)
+	}
+	candidates := b.candidates()
+	if len(candidates) == 0 {
+		// tutto gia' misurato: si rimisura l'ottimo previsto, cosi' il modello non si butta
+		if point, _, _, ok := b.Predicted(); ok {
+			return point
+		}
+		return slices.Clone(b.start)
+	}
+	if len(b.observations) < max(b.config.Search.InitialPoints, 2) {
+		return candidates[b.rng.Intn(len(candidates))]
+	}
+
+	best, found := math.Inf(1), false
+	for _, o := range b.observations {
+		if o.feasible && o.cost < best {
+			best, found = o.cost, true
+		}
+	}
+	var next []int
+	bestScore := math.Inf(-1)
+	for _, point := range candidates {
+		x := b.scale(point)
+		score := b.probabilityFeasible(x)
+		// senza configurazioni fattibili si cerca solo la fattibilita'
+		if found && b.cost != nil {
+			mu, sigma := b.cost.predict(x)
+			score *= expectedImprovement(mu, sigma, best)
+		}
+		if score > bestScore {
+			next, bestScore = point, score
+		}
+	}
+	return next
+}
+
+func (b *bayesianSearch) Observe(point []int, sample Sample) {
+	o := observation{point: point, cost: math.Inf(1), feasible: feasible(b.config, sample)}
+	if sample.Invalid == "" {
+		o.cost = objective.Cost(sample)
+	}
+	b.observations = append(b.observations, o)
+
+	var costXs, feasibleXs [][]float64
+	var costs, labels []float64
+	for _, o := range b.observations {
+		x := b.scale(o.point)
+		label := -1.0
+		if o.feasible {
+			label = 1
+		}
+		feasibleXs = append(feasibleXs, x)
+		labels = append(labels, label)
+		if !math.IsInf(o.cost, 0) && !math.IsNaN(o.cost) {
+			costXs = append(costXs, x)
+			costs = append(costs, o.cost)
+		}
+	}
+	conf := b.config.Search
+	b.cost = fitGP(costXs, costs, conf.LengthScale, conf.Noise)
+	b.feasibility = fitGP(feasibleXs, labels, conf.LengthScale, conf.Noise)
+}
+
+/*
+Predicted returns the point with the lowest predicted cost among the ones more likely
+feasible than not, measured or not, with its predicted cost and probability of being
+feasible. ok is false before the cost GP has data.
+*/
+func (b *bayesianSearch) Predicted() ([]int, float64, float64, bool) {
+	if b.cost == nil {
+		return nil, 0, 0, false
+	}
+	points := b.candidates()
+	for _, o := range b.observations {
+		points = append(points, o.point)
+	}
+	var optimum []int
+	optimumCost, optimumFeasible := math.Inf(1), 0.0
+	for _, point := range points {
+		x := b.scale(point)
+		pFeasible := b.probabilityFeasible(x)
+		if pFeasible < 0.5 {
+			continue
+		}
+		if mu, _ := b.cost.predict(x); mu < optimumCost {
+			optimum, optimumCost, optimumFeasible = point, mu, pFeasible
+		}
+	}
+	return optimum, optimumCost, optimumFeasible, optimum != nil
+}
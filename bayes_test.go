@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCholesky(t *testing.T) {
+	a := [][]float64{{4, 2, 0}, {2, 3, 1}, {0, 1, 2}}
+	l := cholesky(a)
+	// l l^T deve ridare a, con zeri sopra la diagonale
+	for i := range a {
+		for j := range a {
+			var sum float64
+			for k := range a {
+				sum += l[i][k] * l[j][k]
+			}
+			if !near(sum, a[i][j], 1e-12) {
+				t.Errorf("(l l^T)[%d][%d] = %g, want %g", i, j, sum, a[i][j])
+			}
+			if j > i && l[i][j] != 0 {
+				t.Errorf("l[%d][%d] = %g above the diagonal", i, j, l[i][j])
+			}
+		}
+	}
+	if l[0][0] != 2 || l[1][0] != 1 || !near(l[1][1], math.Sqrt2, 1e-12) {
+		t.Errorf("cholesky(%v) = %v", a, l)
+	}
+
+	x := solveUpper(l, solveLower(l, []float64{2, 4, 3}))
+	for i := range a {
+		var sum float64
+		for j := range a {
+			sum += a[i][j] * x[j]
+		}
+		if want := []float64{2, 4, 3}[i]; !near(sum, want, 1e-12) {
+			t.Errorf("(a x)[%d] = %g, want %g", i, sum, want)
+		}
+	}
+}
+
+func TestFitGP(t *testing.T) {
+	if gp := fitGP(nil, nil, 0.2, 1e-6); gp != nil {
+		t.Errorf("fitGP without observations = %v, want nil", gp)
+	}
+
+	xs := [][]float64{{0}, {0.5}, {1}}
+	ys := []float64{10, 20, 15}
+	gp := fitGP(xs, ys, 0.2, 1e-6)
+	// con poco rumore passa per le osservazioni, quasi senza incertezza
+	for i, x := range xs {
+		mu, sigma := gp.predict(x)
+		if !near(mu, ys[i], 1e-2) || sigma > 0.1 {
+			t.Errorf("predict(%v) = %g, %g, want %g with sigma near 0", x, mu, sigma, ys[i])
+		}
+	}
+	// lontano dai dati torna alla media a priori
+	mu, sigma := gp.predict([]float64{10})
+	if !near(mu, 15, 1e-9) || !near(sigma, math.Sqrt(variance(ys)), 1e-6) {
+		t.Errorf("predict far away = %g, %g, want 15, %g", mu, sigma, math.Sqrt(variance(ys)))
+	}
+
+	// valori tutti uguali: scala 1, niente divisioni per zero
+	gp = fitGP([][]float64{{0}, {1}}, []float64{7, 7}, 0.2, 1e-6)
+	if mu, sigma := gp.predict([]float64{0.5}); !near(mu, 7, 1e-9) || math.IsNaN(sigma) {
+		t.Errorf("predict on constant targets = %g, %g, want 7", mu, sigma)
+	}
+}
+
+func TestExpectedImprovement(t *testing.T) {
+	tests := []struct {
+		name            string
+		mu, sigma, best float64
+		want            float64
+	}{
+		{"at the best", 5, 2, 5, 2 / math.Sqrt(2*math.Pi)},
+		// senza incertezza è il miglioramento stesso, o niente
+		{"certain improvement", 3, 1e-9, 5, 2},
+		{"certain worsening", 7, 1e-9, 5, 0},
+	}
+	for _, test := range tests {
+		if got := expectedImprovement(test.mu, test.sigma, test.best); !near(got, test.want, 1e-9) {
+			t.Errorf("%s: expectedImprovement(%g, %g, %g) = %g, want %g", test.name, test.mu, test.sigma, test.best, got, test.want)
+		}
+	}
+	if expectedImprovement(6, 1, 5) <= expectedImprovement(6, 0.1, 5) {
+		t.Errorf("expectedImprovement does not grow with the uncertainty")
+	}
+}
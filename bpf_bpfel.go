@@ -14,6 +14,14 @@ import (
 
 type bpfCountmin struct{ Values [4][1048576]uint64 }
 
+type bpfPkt5tuple struct {
+	SrcIp   uint32
+	DstIp   uint32
+	SrcPort uint16
+	DstPort uint16
+	Proto   uint8
+}
+
 // loadBpf returns the embedded CollectionSpec for bpf.
 func loadBpf() (*ebpf.CollectionSpec, error) {
 	reader := bytes.NewReader(_BpfBytes)
@@ -64,6 +72,7 @@ type bpfProgramSpecs struct {
 // It can be passed ebpf.CollectionSpec.Assign.
 type bpfMapSpecs struct {
 	Countmin *ebpf.MapSpec `ebpf:"countmin"`
+	Heavy    *ebpf.MapSpec `ebpf:"heavy"`
 }
 
 // bpfVariableSpecs contains global variables before they are loaded into the kernel.
@@ -93,11 +102,13 @@ func (o *bpfObjects) Close() error {
 // It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
 type bpfMaps struct {
 	Countmin *ebpf.Map `ebpf:"countmin"`
+	Heavy    *ebpf.Map `ebpf:"heavy"`
 }
 
 func (m *bpfMaps) Close() error {
 	return _BpfClose(
 		m.Countmin,
+		m.Heavy,
 	)
 }
 
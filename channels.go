@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// finestra dopo una riconfigurazione in cui si contano i pacchetti persi
+const DISRUPTION_WINDOW = 1
+
+// disruptionLog records the cost of every reconfiguration, nil disables it
+var disruptionLog *csv.Writer
+
+func getChannels(ethHandle *ethtool.Ethtool, iface string) ethtool.Channels {
+	channels, err := ethHandle.GetChannels(iface)
+	if err != nil {
+		panic(err.Error())
+	}
+	return channels
+}
+
+func setChannels(ethHandle *ethtool.Ethtool, iface string, combined uint32) ethtool.Channels {
+	channels := getChannels(ethHandle, iface)
+	if combined > channels.MaxCombined {
+		panic(fmt.Sprintf("%s supports at most %d combined channels, requested %d", iface, channels.MaxCombined, combined))
+	}
+	channels.CombinedCount = combined
+	channels, err := ethHandle.SetChannels(iface, channels)
+	if err != nil {
+		panic(err.Error())
+	}
+	return channels
+}
+
+/*
+getQueueIRQs returns the IRQ of every completion queue of the interface, in queue order.
+The lines of /proc/interrupts are matched against the interface name and its PCI address
+(mlx5 names its vectors mlx5_comp<N>@pci:<bus>), async and control vectors are skipped.
+*/
+func getQueueIRQs(ethHandle *ethtool.Ethtool, iface string) []int {
+	busInfo, err := ethHandle.BusInfo(iface)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	file, err := os.Open("/proc/interrupts")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer file.Close()
+
+	var irqs []int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		name := fields[len(fields)-1]
+		if !strings.Contains(name, iface) && (busInfo == "" || !strings.Contains(name, busInfo)) {
+			continue
+		}
+		if strings.Contains(name, "async") || strings.Contains(name, "ctrl") {
+			continue
+		}
+		irq, err := strconv.Atoi(strings.TrimSuffix(fields[0], ":"))
+		if err != nil {
+			continue
+		}
+		irqs = append(irqs, irq)
+	}
+	if err := scanner.Err(); err != nil {
+		panic(err.Error())
+	}
+	return irqs
+}
+
+func setIRQAffinity(irq int, cpu int) {
+	path := fmt.Sprintf("/proc/irq/%d/smp_affinity_list", irq)
+	err := os.WriteFile(path, []byte(strconv.Itoa(cpu)), 0644)
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// applyIRQAffinity pins the IRQ of queue i to config.CoreSet[i] for every channel
+func applyIRQAffinity(ethHandle *ethtool.Ethtool, config Config) {
+	irqs := getQueueIRQs(ethHandle, config.Iface)
+	for queue := 0; queue < len(irqs) && queue < len(config.CoreSet); queue++ {
+		setIRQAffinity(irqs[queue], config.CoreSet[queue])
+	}
+}
+
+func createDisruptionCSV() *csv.Writer {
+	file, err := os.Create("disruption.csv")
+	if err != nil {
+		panic(err.Error())
+	}
+
+	writer := csv.NewWriter(file)
+
+	err = writer.Write([]string{"knob", "from", "to", "duration_ms", "lost", "time"})
+	if err != nil {
+		file.Close()
+		panic(err.Error())
+	}
+
+	writer.Flush()
+
+	return writer
+}
+
+func recordDisruption(knob string, from string, to string, duration time.Duration, lost uint64) {
+	p := message.NewPrinter(language.English)
+	p.Printf("Switching %s from %s to %s took %d ms and lost %d packets\n", knob, from, to, duration.Milliseconds(), lost)
+
+	if disruptionLog == nil {
+		return
+	}
+	err := disruptionLog.Write([]string{knob, from, to, fmt.Sprintf("%d", duration.Milliseconds()), fmt.Sprintf("%d", lost), time.Now().Format("15:04:05")})
+	if err != nil {
+		panic(err.Error())
+	}
+	disruptionLog.Flush()
+}
+
+/*
+applyChannels moves the interface from config.Cores to cores combined channels,
+keeping the indirection table and the IRQ affinity consistent. The packets lost
+while the queues are recreated are counted by the caller through reconfigure.
+*/
+func applyChannels(ethHandle *ethtool.Ethtool, config Config, cores uint32) Config {
+	oldCores := config.Cores
+	config.Cores = cores
+	config.Weight = createSlice(cores, 0)
+
+	// il kernel rifiuta di togliere canali ancora usati dalla tabella di indirezione o dalle regole
+	if cores < oldCores {
+		if steering != nil {
+			steering.releaseQueues(cores)
+		}
+		setIndir(config)
+		setChannels(ethHandle, config.Iface, cores)
+	} else {
+		setChannels(ethHandle, config.Iface, cores)
+		setIndir(config)
+	}
+	applyIRQAffinity(ethHandle, config)
+	return config
+}
@@ -34,6 +34,11 @@
 #define HASHFN_N 4
 #define COLUMNS 1048576
 
+// flows whose estimate reaches HH_THRESHOLD are reported every HH_SAMPLE packets
+#define HH_THRESHOLD 65536
+#define HH_SAMPLE 1024
+#define HH_ENTRIES 1024
+
 struct countmin
 {
     __u64 values[HASHFN_N][COLUMNS];
@@ -56,6 +61,14 @@ struct
     __type(value, struct countmin);
 } countmin SEC(".maps");
 
+struct
+{
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, HH_ENTRIES);
+    __type(key, struct pkt_5tuple);
+    __type(value, __u64);
+} heavy SEC(".maps");
+
 static __always_inline void hash(const void *pkt, const __u64 len, __u16 hashes[4])
 {
     __u64 h = xxhash64(pkt, len, _SEED_HASHFN);
@@ -68,15 +81,18 @@ static __always_inline void hash(const void *pkt, const __u64 len, __u16 hashes[
 
 #define ARRAY_SIZE(x) (sizeof(x) / sizeof((x)[0]))
 
-static __always_inline void countmin_add(struct countmin *cm, const __u16 hashes[4])
+static __always_inline __u64 countmin_add(struct countmin *cm, const __u16 hashes[4])
 {
+    __u64 estimate = (__u64)-1;
     for (int i = 0; i < HASHFN_N; i++)
     {
         __u32 target_idx = hashes[i] & (COLUMNS - 1);
         //__sync_fetch_and_add(&cm->values[i][target_idx], 1); //;< -this crash clang
         cm->values[i][target_idx]++;
+        if (cm->values[i][target_idx] < estimate)
+            estimate = cm->values[i][target_idx];
     }
-    return;
+    return estimate;
 }
 
 static __always_inline int handle_pkt(void *data, void *data_end, struct pkt_5tuple *pkt)
@@ -145,7 +161,9 @@ int cms(struct xdp_md *ctx)
     if (ret)
         return ret;
     hash(&pkt1, sizeof(pkt1), pkt1_hashes);
-    countmin_add(cm, pkt1_hashes);
+    __u64 estimate = countmin_add(cm, pkt1_hashes);
+    if (estimate >= HH_THRESHOLD && (estimate & (HH_SAMPLE - 1)) == 0)
+        bpf_map_update_elem(&heavy, &pkt1, &estimate, BPF_ANY);
     return XDP_DROP;
     // return XDP_TX;
 }
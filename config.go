@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+var configPath = flag.String("config", "", "JSON file overriding the default configuration")
+
+/*
+loadConfig overrides the defaults with the fields present in the JSON file at path,
+the ones it does not mention keep their default. Field names are the ones of Config,
+e.g. {"Objective": {"Name": "weighted", "Weights": {"cpu": 1, "energy": 0.5}},
+"Constraints": {"MaxLossRate": 0.001, "Ranges": {"budget": [8, 256]}}}.
+
+Ranges are in the unit of the knob, written as JSON numbers:
+  - rxqueue: descriptors, budget: packets per NAPI poll, cores: RX cores
+  - cqe_compress, striding: 0 for false, 1 for true
+  - MSR knobs (e.g. ddio) and l3_mask: the register field or cache mask as a number,
+    e.g. [1024, 2047] for 0x400 to 0x7ff, JSON has no hex
+  - min_freq, max_freq, uncore_min_freq, uncore_max_freq: kHz, as in sysfs
+  - governor, epp and idle take names, a range on them is ignored
+*/
+func loadConfig(path string, config Config) Config {
+	if path == "" {
+		return config
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		panic(err.Error())
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		panic(fmt.Sprintf("parsing %s: %s", path, err))
+	}
+	fmt.Printf("Configuration loaded from %s\n", path)
+	return config
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// RSSContextConfig describes an RSS context created for a workload other than the tuner
+type RSSContextConfig struct {
+	Name   string
+	Queues []uint32     // queues the context spreads its traffic on
+	Rules  []NtupleRule // flows sent to the context, Queue is relative to Queues
+}
+
+// rssContext is a context created at startup, removed on exit
+type rssContext struct {
+	name  string
+	id    uint32
+	rules []uint32
+}
+
+// reservedQueues returns the lowest queue used by the other workloads, the tuner stays below it
+func reservedQueues(config Config) (uint32, bool) {
+	var queues []uint32
+	for _, context := range config.Contexts {
+		queues = append(queues, context.Queues...)
+	}
+	if len(queues) == 0 {
+		return 0, false
+	}
+	return slices.Min(queues), true
+}
+
+/*
+createContexts creates the RSS context of every workload and binds its ntuple rules
+to it. The rules must not use the locations reserved for heavy flow steering.
+*/
+func createContexts(config Config) []rssContext {
+	var created []rssContext
+	for _, conf := range config.Contexts {
+		id, err := createRSSContext(config.Iface, conf.Queues)
+		if err != nil {
+			panic(err.Error())
+		}
+		context := rssContext{name: conf.Name, id: id}
+		fmt.Printf("RSS context %d created for %s on queues %v\n", id, conf.Name, conf.Queues)
+
+		for _, rule := range conf.Rules {
+			steered := config.Steering.Enabled && rule.Location >= config.Steering.FirstRule && rule.Location < config.Steering.FirstRule+config.Steering.MaxRules
+			if steered {
+				panic(fmt.Sprintf("rule %d of %s overlaps the heavy flow rules", rule.Location, conf.Name))
+			}
+			rule.Context = id
+			if err := insertNtupleRule(config.Iface, rule); err != nil {
+				panic(err.Error())
+			}
+			context.rules = append(context.rules, rule.Location)
+		}
+		created = append(created, context)
+	}
+	return created
+}
+
+// deleteContexts removes the rules first, a context still referenced by a rule cannot be deleted
+func deleteContexts(iface string, contexts []rssContext) {
+	for _, context := range contexts {
+		for _, location := range context.rules {
+			if err := deleteNtupleRule(iface, location); err != nil {
+				fmt.Printf("%s\n", err)
+			}
+		}
+		if err := deleteRSSContext(iface, context.id); err != nil {
+			fmt.Printf("%s\n", err)
+			continue
+		}
+		fmt.Printf("RSS context %d of %s deleted\n", context.id, context.name)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestReservedQueues(t *testing.T) {
+	tests := []struct {
+		name     string
+		contexts []RSSContextConfig
+		want     uint32
+		wantOK   bool
+	}{
+		{"no contexts", nil, 0, false},
+		{"no queues", []RSSContextConfig{{Name: "empty"}}, 0, false},
+		// il tuner resta sotto la coda più bassa di tutti i contesti
+		{"lowest of all", []RSSContextConfig{{Name: "a", Queues: []uint32{12, 13}}, {Name: "b", Queues: []uint32{10, 11}}}, 10, true},
+	}
+	for _, test := range tests {
+		got, ok := reservedQueues(Config{Contexts: test.contexts})
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("%s: reservedQueues = %d, %t, want %d, %t", test.name, got, ok, test.want, test.wantOK)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseCPUList parses the kernel cpulist format, e.g. "0-3,8,10-11"
+func parseCPUList(list string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(strings.TrimSpace(list), ",") {
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list %q: %w", list, err)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpu list %q: %w", list, err)
+			}
+		}
+		if last < first {
+			return nil, fmt.Errorf("invalid cpu range %q", part)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			if !slices.Contains(cpus, cpu) {
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+	return cpus, nil
+}
+
+func readCPUList(path string) []int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		panic(err.Error())
+	}
+	cpus, err := parseCPUList(string(data))
+	if err != nil {
+		panic(err.Error())
+	}
+	return cpus
+}
+
+// getNICNumaNode returns the NUMA node of the NIC, -1 if the platform does not report one
+func getNICNumaNode(iface string) int {
+	data, err := os.ReadFile(fmt.Sprintf("/sys/class/net/%s/device/numa_node", iface))
+	if err != nil {
+		return -1
+	}
+	node, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return -1
+	}
+	return node
+}
+
+// isSMTSibling reports whether cpu is not the first hardware thread of its core
+func isSMTSibling(cpu int) bool {
+	siblings := readCPUList(fmt.Sprintf("/sys/devices/system/cpu/cpu%d/topology/thread_siblings_list", cpu))
+	return len(siblings) > 0 && slices.Min(siblings) != cpu
+}
+
+/*
+selectCores returns the CPUs the RX queues are bound to, queue i goes to cpus[i].
+An explicit cpuList wins, otherwise the online CPUs of the NIC's NUMA node are used.
+Unless smt is set only the first hardware thread of every physical core is kept.
+*/
+func selectCores(iface string, cpuList string, smt bool) []int {
+	var cpus []int
+	if cpuList != "" {
+		var err error
+		cpus, err = parseCPUList(cpuList)
+		if err != nil {
+			panic(err.Error())
+		}
+	} else if node := getNICNumaNode(iface); node >= 0 {
+		cpus = readCPUList(fmt.Sprintf("/sys/devices/system/node/node%d/cpulist", node))
+		fmt.Printf("%s is on NUMA node %d\n", iface, node)
+	} else {
+		cpus = readCPUList("/sys/devices/system/cpu/online")
+	}
+
+	if !smt {
+		cpus = slices.DeleteFunc(cpus, isSMTSibling)
+	}
+	if len(cpus) == 0 {
+		panic(fmt.Sprintf("no usable cores for %s", iface))
+	}
+	fmt.Printf("RX cores %v\n", cpus)
+	return cpus
+}
+
+// activeQueues returns the queues that currently receive traffic, queue i is served by CoreSet[i]
+func activeQueues(config Config) []uint32 {
+	var queues []uint32
+	for queue := range config.CoreSet {
+		if queue < len(config.Weight) && config.Weight[queue] > 0 {
+			queues = append(queues, uint32(queue))
+		}
+	}
+	return queues
+}
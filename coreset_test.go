@@ -0,0 +1,39 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		list string
+		want []int
+	}{
+		{"0-3,8,10-11", []int{0, 1, 2, 3, 8, 10, 11}},
+		{"5", []int{5}},
+		{"0-1\n", []int{0, 1}},
+		{"", nil},
+		{"4,,6", []int{4, 6}},
+		// i duplicati contano una volta sola, nell'ordine in cui compaiono
+		{"2,2,1-2", []int{2, 1}},
+	}
+	for _, test := range tests {
+		got, err := parseCPUList(test.list)
+		if err != nil {
+			t.Errorf("parseCPUList(%q): %s", test.list, err)
+			continue
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("parseCPUList(%q) = %v, want %v", test.list, got, test.want)
+		}
+	}
+}
+
+func TestParseCPUListInvalid(t *testing.T) {
+	for _, list := range []string{"a", "1-x", "5-3", "-1", "1-2-3"} {
+		if cpus, err := parseCPUList(list); err == nil {
+			t.Errorf("parseCPUList(%q) = %v, want an error", list, cpus)
+		}
+	}
+}
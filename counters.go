@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+const (
+	MAX_REMEASURE    = 3    // windows tried again when the counters cannot be trusted
+	ACTION_PHY_SLACK = 1.01 // the action rate may exceed rx_packets_phy only by this factor
+)
+
+/*
+counterDelta returns post - pre. The 64 bit driver counters do not wrap in practice, so
+a counter going back was reset, by a ring change or a driver reload, and the delta is
+not valid.
+*/
+func counterDelta(pre uint64, post uint64) (uint64, bool) {
+	if post < pre {
+		return 0, false
+	}
+	return post - pre, true
+}
+
+// checkCounters returns why the deltas of a window cannot be trusted, "" when they can, the loss counters included
+func checkCounters(pre map[string]uint64, post map[string]uint64, action string) string {
+	counters := []string{"rx_packets_phy", action}
+	// anche quelli delle perdite, azzerati farebbero sparire la causa dal breakdown
+	for _, cause := range lossOrder {
+		counters = append(counters, lossCounters[cause]...)
+	}
+	for _, counter := range counters {
+		if _, ok := counterDelta(pre[counter], post[counter]); !ok {
+			return fmt.Sprintf("%s went from %d to %d", counter, pre[counter], post[counter])
+		}
+	}
+	phy := post["rx_packets_phy"] - pre["rx_packets_phy"]
+	act := post[action] - pre[action]
+	if float64(act) > float64(phy)*ACTION_PHY_SLACK && act-phy > DROPPED_THRESHOLD {
+		return fmt.Sprintf("%s grew by %d, more than rx_packets_phy by %d", action, act, phy)
+	}
+	return ""
+}
+
+/*
+measureFor runs one measurement window of the current configuration, measuring it again
+when the counters were reset or are inconsistent. After MAX_REMEASURE attempts the last
+sample is returned marked invalid, feasible rejects it.
+*/
+func measureFor(ethHandle *ethtool.Ethtool, config Config, duration time.Duration) Sample {
+	p := message.NewPrinter(language.English)
+	var s Sample
+	for attempt := range MAX_REMEASURE {
+		s = measureWindow(ethHandle, config, duration)
+		if s.Invalid == "" {
+			return s
+		}
+		p.Printf("Invalid sample (%s), measuring again %d/%d\n", s.Invalid, attempt+1, MAX_REMEASURE)
+	}
+	return s
+}
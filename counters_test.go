@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name      string
+		pre, post uint64
+		want      uint64
+		wantOK    bool
+	}{
+		{"grown", 100, 250, 150, true},
+		{"unchanged", 42, 42, 0, true},
+		{"from zero", 0, 7, 7, true},
+		// un contatore che torna indietro è stato azzerato
+		{"reset", 1000, 10, 0, false},
+		{"reset to zero", 1, 0, 0, false},
+	}
+	for _, test := range tests {
+		got, ok := counterDelta(test.pre, test.post)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("%s: counterDelta(%d, %d) = %d, %t, want %d, %t", test.name, test.pre, test.post, got, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestCheckCounters(t *testing.T) {
+	pre := map[string]uint64{"rx_packets_phy": 1000, "rx_xdp_drop": 1000, "rx_out_of_buffer": 50}
+	tests := []struct {
+		name string
+		post map[string]uint64
+		want string // contenuto nel motivo, "" quando il campione è valido
+	}{
+		{"consistent", map[string]uint64{"rx_packets_phy": 2000, "rx_xdp_drop": 1900, "rx_out_of_buffer": 80}, ""},
+		{"phy reset", map[string]uint64{"rx_packets_phy": 10, "rx_xdp_drop": 1900, "rx_out_of_buffer": 80}, "rx_packets_phy"},
+		{"action reset", map[string]uint64{"rx_packets_phy": 2000, "rx_xdp_drop": 5, "rx_out_of_buffer": 80}, "rx_xdp_drop"},
+		{"loss counter reset", map[string]uint64{"rx_packets_phy": 2000, "rx_xdp_drop": 1900, "rx_out_of_buffer": 0}, "rx_out_of_buffer"},
+		// l'azione può superare phy solo di poco
+		{"action above phy", map[string]uint64{"rx_packets_phy": 2000, "rx_xdp_drop": 3000, "rx_out_of_buffer": 80}, "more than rx_packets_phy"},
+		{"action slightly above phy", map[string]uint64{"rx_packets_phy": 2000, "rx_xdp_drop": 2050, "rx_out_of_buffer": 80}, ""},
+	}
+	for _, test := range tests {
+		got := checkCounters(pre, test.post, "rx_xdp_drop")
+		if test.want == "" && got != "" || test.want != "" && !strings.Contains(got, test.want) {
+			t.Errorf("%s: checkCounters = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
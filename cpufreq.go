@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type CPUFreqConfig struct {
+	Governor bool   // sweep scaling_governor
+	MinFreq  bool   // sweep scaling_min_freq
+	MaxFreq  bool   // sweep scaling_max_freq
+	EPP      bool   // sweep energy_performance_preference
+	Uncore   bool   // sweep the uncore min and max frequency of the NIC socket
+	Step     uint64 // kHz between two frequencies when the driver does not list them
+}
+
+func cpufreqFiles(cpus []int, attribute string) []string {
+	var files []string
+	for _, cpu := range cpus {
+		files = append(files, fmt.Sprintf("/sys/devices/system/cpu/cpu%d/cpufreq/%s", cpu, attribute))
+	}
+	return files
+}
+
+// freqSteps returns the frequencies from min to max, step kHz apart
+func freqSteps(min uint64, max uint64, step uint64) []string {
+	var values []string
+	for freq := min; freq <= max; freq += step {
+		values = append(values, strconv.FormatUint(freq, 10))
+	}
+	if len(values) > 0 && values[len(values)-1] != strconv.FormatUint(max, 10) {
+		values = append(values, strconv.FormatUint(max, 10))
+	}
+	return values
+}
+
+// availableFreqs returns the frequencies of a core, listed by the driver or stepped between its limits
+func availableFreqs(cpu int, step uint64) []string {
+	dir := fmt.Sprintf("/sys/devices/system/cpu/cpu%d/cpufreq", cpu)
+	if list, err := readSysfs(filepath.Join(dir, "scaling_available_frequencies")); err == nil {
+		values := strings.Fields(list)
+		sortValues(values)
+		return values
+	}
+	min, errMin := readUint(filepath.Join(dir, "cpuinfo_min_freq"), 10)
+	max, errMax := readUint(filepath.Join(dir, "cpuinfo_max_freq"), 10)
+	if errMin != nil || errMax != nil {
+		return nil
+	}
+	return freqSteps(min, max, step)
+}
+
+/*
+cpufreqKnobs returns the core frequency knobs of the RX cores and the uncore ones of the
+socket hosting the NIC. Allowed values come from the first RX core and the driver.
+*/
+func cpufreqKnobs(config Config) []SysfsKnob {
+	conf := config.CPUFreq
+	if conf.Step == 0 {
+		conf.Step = 100000
+	}
+	cpus := config.CoreSet
+	first := fmt.Sprintf("/sys/devices/system/cpu/cpu%d/cpufreq", cpus[0])
+
+	var knobs []SysfsKnob
+	if conf.Governor {
+		governors, _ := readSysfs(filepath.Join(first, "scaling_available_governors"))
+		knobs = append(knobs, SysfsKnob{Name: "governor", Files: cpufreqFiles(cpus, "scaling_governor"), Values: strings.Fields(governors)})
+	}
+	if conf.MinFreq {
+		knobs = append(knobs, SysfsKnob{Name: "min_freq", Files: cpufreqFiles(cpus, "scaling_min_freq"), Values: availableFreqs(cpus[0], conf.Step)})
+	}
+	if conf.MaxFreq {
+		knobs = append(knobs, SysfsKnob{Name: "max_freq", Files: cpufreqFiles(cpus, "scaling_max_freq"), Values: availableFreqs(cpus[0], conf.Step)})
+	}
+	if conf.EPP {
+		preferences, _ := readSysfs(filepath.Join(first, "energy_performance_available_preferences"))
+		knobs = append(knobs, SysfsKnob{Name: "epp", Files: cpufreqFiles(cpus, "energy_performance_preference"), Values: strings.Fields(preferences)})
+	}
+	if conf.Uncore {
+		knobs = append(knobs, uncoreKnobs(config.Iface, conf.Step)...)
+	}
+	return knobs
+}
+
+// uncoreKnobs returns the intel_uncore_frequency limits of every die of the NIC socket
+func uncoreKnobs(iface string, step uint64) []SysfsKnob {
+	socket := getNICSocket(iface)
+	if socket < 0 {
+		socket = 0
+	}
+	dirs, _ := filepath.Glob(fmt.Sprintf("/sys/devices/system/cpu/intel_uncore_frequency/package_%02d_die_*", socket))
+	if len(dirs) == 0 {
+		fmt.Printf("No intel_uncore_frequency for socket %d, uncore knobs disabled\n", socket)
+		return nil
+	}
+
+	// i limiti iniziali sono quelli del firmware, il range non puo' uscirne
+	min, errMin := readUint(filepath.Join(dirs[0], "initial_min_freq_khz"), 10)
+	max, errMax := readUint(filepath.Join(dirs[0], "initial_max_freq_khz"), 10)
+	if errMin != nil || errMax != nil {
+		fmt.Printf("Cannot read the uncore limits of socket %d, uncore knobs disabled\n", socket)
+		return nil
+	}
+
+	var minFiles, maxFiles []string
+	for _, dir := range dirs {
+		minFiles = append(minFiles, filepath.Join(dir, "min_freq_khz"))
+		maxFiles = append(maxFiles, filepath.Join(dir, "max_freq_khz"))
+	}
+	return []SysfsKnob{
+		{Name: "uncore_min_freq", Files: minFiles, Values: freqSteps(min, max, step)},
+		{Name: "uncore_max_freq", Files: maxFiles, Values: freqSteps(min, max, step)},
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type CPUModel struct {
+	Vendor string
+	Family int
+	Model  int
+}
+
+func (m CPUModel) String() string {
+	return fmt.Sprintf("%s family %d model %#x", m.Vendor, m.Family, m.Model)
+}
+
+// models where each register has been checked to mean what the knobs expect
+var msrModels = map[uint32][]CPUModel{
+	// IIO LLC WAYS: Skylake-SP/Cascade Lake, Ice Lake-SP, Ice Lake-D, Sapphire Rapids, Emerald Rapids
+	MSR_IIO_LLC_WAYS: {
+		{"GenuineIntel", 6, 0x55},
+		{"GenuineIntel", 6, 0x6a},
+		{"GenuineIntel", 6, 0x6c},
+		{"GenuineIntel", 6, 0x8f},
+		{"GenuineIntel", 6, 0xcf},
+	},
+}
+
+// detectCPU reads vendor, family and model of the first processor from /proc/cpuinfo
+func detectCPU() CPUModel {
+	file, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer file.Close()
+
+	var model CPUModel
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// basta il primo processore
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		switch key {
+		case "vendor_id":
+			model.Vendor = value
+		case "cpu family":
+			model.Family, _ = strconv.Atoi(value)
+		case "model":
+			model.Model, _ = strconv.Atoi(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		panic(err.Error())
+	}
+	return model
+}
+
+// msrSupported reports whether the knob is known to be valid on the CPU, knob.Models wins over msrModels
+func msrSupported(k MSRKnob, cpu CPUModel) bool {
+	models := k.Models
+	if len(models) == 0 {
+		models = msrModels[k.Register]
+	}
+	return slices.Contains(models, cpu)
+}
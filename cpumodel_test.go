@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestMSRSupported(t *testing.T) {
+	skylake := CPUModel{"GenuineIntel", 6, 0x55}
+	zen := CPUModel{"AuthenticAMD", 25, 0x1}
+	tests := []struct {
+		name string
+		knob MSRKnob
+		cpu  CPUModel
+		want bool
+	}{
+		{"listed model", MSRKnob{Register: MSR_IIO_LLC_WAYS}, skylake, true},
+		{"other vendor", MSRKnob{Register: MSR_IIO_LLC_WAYS}, zen, false},
+		{"unknown register", MSRKnob{Register: 0x1a4}, skylake, false},
+		// Models del knob vince su msrModels
+		{"knob models", MSRKnob{Register: MSR_IIO_LLC_WAYS, Models: []CPUModel{zen}}, zen, true},
+		{"knob models exclude", MSRKnob{Register: MSR_IIO_LLC_WAYS, Models: []CPUModel{zen}}, skylake, false},
+	}
+	for _, test := range tests {
+		if got := msrSupported(test.knob, test.cpu); got != test.want {
+			t.Errorf("%s: msrSupported(%#x, %s) = %t, want %t", test.name, test.knob.Register, test.cpu, got, test.want)
+		}
+	}
+}
+
+func TestCPUModelString(t *testing.T) {
+	if got, want := (CPUModel{"GenuineIntel", 6, 0x8f}).String(), "GenuineIntel family 6 model 0x8f"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
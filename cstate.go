@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+)
+
+const (
+	IDLE_CPUIDLE = "cpuidle" // disable the deeper idle states of the RX cores only
+	IDLE_PMQOS   = "pmqos"   // CPU latency request on /dev/cpu_dma_latency, applies to every CPU
+)
+
+// PM QoS default, no latency constraint
+const PM_QOS_NO_CONSTRAINT = 2000000000
+
+type IdleConfig struct {
+	Enabled bool
+	Mode    string
+}
+
+type idleState struct {
+	name    string
+	latency uint64 // exit latency in us
+}
+
+/*
+idleControl limits how deep the RX cores may sleep. The knob is the deepest idle state
+allowed, as an index into the states of the first RX core: 0 is polling only, the last
+one leaves every state enabled.
+*/
+type idleControl struct {
+	mode   string
+	cpus   []int
+	states []idleState
+	qos    *os.File // kept open, closing it drops the request
+}
+
+// idleCtl is nil when idle control is disabled
+var idleCtl *idleControl
+
+func cpuidlePath(cpu int, state int, attribute string) string {
+	return fmt.Sprintf("/sys/devices/system/cpu/cpu%d/cpuidle/state%d/%s", cpu, state, attribute)
+}
+
+// newIdleControl reads the idle states of the RX cores and returns the current limit
+func newIdleControl(conf IdleConfig, config Config) (*idleControl, int, error) {
+	c := &idleControl{mode: conf.Mode, cpus: config.CoreSet}
+	dirs, _ := filepath.Glob(fmt.Sprintf("/sys/devices/system/cpu/cpu%d/cpuidle/state*", c.cpus[0]))
+	for k := range dirs {
+		name, err := readSysfs(cpuidlePath(c.cpus[0], k, "name"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading idle states: %w", err)
+		}
+		latency, err := readUint(cpuidlePath(c.cpus[0], k, "latency"), 10)
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading idle states: %w", err)
+		}
+		c.states = append(c.states, idleState{name, latency})
+	}
+	if len(c.states) < 2 {
+		return nil, 0, fmt.Errorf("no idle state to limit on CPU %d", c.cpus[0])
+	}
+
+	limit := len(c.states) - 1
+	switch conf.Mode {
+	case IDLE_PMQOS:
+		qos, err := os.OpenFile("/dev/cpu_dma_latency", os.O_RDWR, 0)
+		if err != nil {
+			return nil, 0, fmt.Errorf("opening PM QoS: %w", err)
+		}
+		c.qos = qos
+	case IDLE_CPUIDLE:
+		// i valori originali vengono rimessi da restoreSysfs
+		for _, cpu := range c.cpus {
+			for k := 1; k < len(c.states); k++ {
+				path := cpuidlePath(cpu, k, "disable")
+				original, err := readSysfs(path)
+				if err != nil {
+					return nil, 0, fmt.Errorf("reading idle states: %w", err)
+				}
+				sysfsOriginals[path] = original
+			}
+		}
+		for k := 1; k < len(c.states); k++ {
+			if sysfsOriginals[cpuidlePath(c.cpus[0], k, "disable")] != "0" {
+				limit = k - 1
+				break
+			}
+		}
+	default:
+		return nil, 0, fmt.Errorf("unknown idle mode %s", conf.Mode)
+	}
+	fmt.Printf("Idle states %v, deepest allowed %s\n", c.states, c.states[limit].name)
+	return c, limit, nil
+}
+
+// setLimit allows the states up to limit and disables the deeper ones
+func (c *idleControl) setLimit(limit int) error {
+	if c.qos != nil {
+		latency := uint32(PM_QOS_NO_CONSTRAINT)
+		if limit < len(c.states)-1 {
+			latency = uint32(c.states[limit].latency)
+		}
+		if _, err := c.qos.Write(binary.NativeEndian.AppendUint32(nil, latency)); err != nil {
+			return fmt.Errorf("writing PM QoS latency %d: %w", latency, err)
+		}
+		return nil
+	}
+	// lo stato 0 e' il polling, resta sempre abilitato
+	for _, cpu := range c.cpus {
+		for k := 1; k < len(c.states); k++ {
+			disable := "0"
+			if k > limit {
+				disable = "1"
+			}
+			if err := os.WriteFile(cpuidlePath(cpu, k, "disable"), []byte(disable), 0644); err != nil {
+				return fmt.Errorf("limiting CPU %d to %s: %w", cpu, c.states[limit].name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *idleControl) close() {
+	if c.qos != nil {
+		c.qos.Close()
+	}
+}
+
+// idleString returns the deepest idle state allowed for results.csv
+func idleString(config Config) string {
+	if idleCtl == nil {
+		return ""
+	}
+	return idleCtl.states[config.IdleLimit].name
+}
+
+// dimension is the deepest idle state allowed as searched
+func (c *idleControl) dimension(config Config) Dimension {
+	var names []string
+	for _, state := range c.states {
+		names = append(names, state.name)
+	}
+
+	return Dimension{"idle", names, config.IdleLimit, fmt.Sprintf("%d", config.IdleLimit), 10, func(config Config, index int) (Config, error) {
+		if err := c.setLimit(index); err != nil {
+			return config, err
+		}
+		config.IdleLimit = index
+		return config, nil
+	}}
+}
+
+/*
+test and update the deepest idle state allowed on the RX cores, one state shallower and one deeper
+*/
+func (c *idleControl) changeIdleLimit(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, Sample) {
+	config, sample, err := searchNeighbours(ethHandle, config, interval, extDrop, c.dimension(config))
+	if err != nil {
+		panic(err.Error())
+	}
+	return config, sample
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// IIO LLC WAYS, the LLC ways PCIe writes may allocate into
+const MSR_IIO_LLC_WAYS = 0xc8b
+const DDIO_KNOB = "ddio"
+
+// ddioModel is the LLC associativity of a CPU model and the DDIO mask it boots with
+type ddioModel struct {
+	Ways    int
+	Default uint64
+}
+
+// ddioModels are the models whose layout is known, the others among msrModels fall back to sysfs
+var ddioModels = map[CPUModel]ddioModel{
+	{"GenuineIntel", 6, 0x55}: {11, 0x600},  // Skylake-SP/Cascade Lake
+	{"GenuineIntel", 6, 0x6a}: {12, 0xc00},  // Ice Lake-SP
+	{"GenuineIntel", 6, 0x8f}: {15, 0x6000}, // Sapphire Rapids
+}
+
+// getLLCWays returns the associativity of the last level cache, an error without an L3 in sysfs (e.g. in a VM)
+func getLLCWays() (int, error) {
+	data, err := os.ReadFile("/sys/devices/system/cpu/cpu0/cache/index3/ways_of_associativity")
+	if err != nil {
+		return 0, err
+	}
+	ways, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("parsing LLC ways: %w", err)
+	}
+	return ways, nil
+}
+
+/*
+ddioMasks returns the valid DDIO way masks from 1 way to all of them. The hardware
+wants a contiguous mask and DDIO conventionally takes the highest ways (the default is
+0x600 with 11 ways and 0x6000 with 15), so the masks grow downwards from the top way.
+*/
+func ddioMasks(ways int) []uint64 {
+	var masks []uint64
+	for n := 1; n <= ways; n++ {
+		masks = append(masks, ((uint64(1)<<n)-1)<<(ways-n))
+	}
+	return masks
+}
+
+// ddioWays returns the number of ways of a DDIO mask
+func ddioWays(mask uint64) int {
+	return bits.OnesCount64(mask)
+}
+
+/*
+newDDIOKnob returns the DDIO knob for the CPU, written only on the socket hosting the
+NIC. The ways and the default mask come from ddioModels, for the other supported models
+the ways are read from sysfs and the default is the top two. An unsupported CPU is an
+error, so the caller can disable the knob.
+*/
+func newDDIOKnob(cpu CPUModel) (MSRKnob, error) {
+	if !slices.Contains(msrModels[MSR_IIO_LLC_WAYS], cpu) {
+		return MSRKnob{}, fmt.Errorf("DDIO is unsupported on %s", cpu)
+	}
+	model, ok := ddioModels[cpu]
+	if !ok {
+		ways, err := getLLCWays()
+		if err != nil {
+			return MSRKnob{}, err
+		}
+		if ways < 1 {
+			return MSRKnob{}, fmt.Errorf("LLC reports %d ways", ways)
+		}
+		model = ddioModel{ways, ddioMasks(ways)[min(2, ways)-1]}
+	}
+	fmt.Printf("LLC has %d ways, default DDIO mask %#x\n", model.Ways, model.Default)
+
+	values := ddioMasks(model.Ways)
+	if !slices.Contains(values, model.Default) {
+		values = append(values, model.Default)
+		slices.Sort(values)
+	}
+	return MSRKnob{
+		Name:     DDIO_KNOB,
+		Register: MSR_IIO_LLC_WAYS,
+		Values:   values,
+		Scope:    MSR_SCOPE_SOCKET,
+		Socket:   -1,
+	}, nil
+}
+
+// ddioString returns the DDIO ways for results.csv, empty when the knob is not used
+func ddioString(config Config) string {
+	value, ok := config.MSRValues[DDIO_KNOB]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%d", ddioWays(value))
+}
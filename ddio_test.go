@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math/bits"
+	"slices"
+	"testing"
+)
+
+func TestDDIOMasks(t *testing.T) {
+	tests := []struct {
+		ways int
+		want []uint64
+	}{
+		{1, []uint64{0x1}},
+		{4, []uint64{0x8, 0xc, 0xe, 0xf}},
+		{0, nil},
+	}
+	for _, test := range tests {
+		if got := ddioMasks(test.ways); !slices.Equal(got, test.want) {
+			t.Errorf("ddioMasks(%d) = %#x, want %#x", test.ways, got, test.want)
+		}
+	}
+}
+
+func TestDDIOMasksContiguous(t *testing.T) {
+	for cpu, model := range ddioModels {
+		masks := ddioMasks(model.Ways)
+		if len(masks) != model.Ways {
+			t.Errorf("%s: %d masks, want %d", cpu, len(masks), model.Ways)
+		}
+		for i, mask := range masks {
+			// contigua e attaccata alla via più alta
+			if ddioWays(mask) != i+1 || bits.Len64(mask) != model.Ways || bits.LeadingZeros64(mask)+bits.OnesCount64(mask)+bits.TrailingZeros64(mask) != 64 {
+				t.Errorf("%s: mask %d = %#x is not the top %d ways", cpu, i, mask, i+1)
+			}
+		}
+		// la maschera di boot deve essere tra quelle provate
+		if !slices.Contains(masks, model.Default) {
+			t.Errorf("%s: default %#x not among %#x", cpu, model.Default, masks)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+type SteeringConfig struct {
+	Enabled   bool
+	HeavyPPS  uint64        // estimated rate above which a flow gets its own rule
+	ColdPPS   uint64        // rate below which a steered flow is considered cold
+	ColdAfter time.Duration // how long a flow stays cold before its rule is removed
+	MaxRules  uint32        // rules installed at most on the NIC
+	FirstRule uint32        // first rule location owned by the tuner
+	Queues    []uint32      // dedicated queues for heavy flows, empty uses the least loaded one
+	Period    time.Duration // how often the heavy hitters are read
+}
+
+type steeredFlow struct {
+	rule      NtupleRule
+	installed time.Time
+	lastHot   time.Time
+}
+
+/*
+flowSteering pins the heavy hitters reported by the count-min sketch to a queue with
+ntuple rules, so that a single elephant flow does not saturate the queue RSS gives it.
+It runs next to the tuning loop, hence the mutex, until close stops it.
+*/
+type flowSteering struct {
+	mu        sync.Mutex
+	conf      SteeringConfig
+	iface     string
+	context   uint32
+	coreSet   []int
+	heavy     *ebpf.Map
+	counts    map[bpfPkt5tuple]uint64
+	flows     map[bpfPkt5tuple]*steeredFlow
+	lastPoll  time.Time
+	nextQueue int
+	done      chan struct{} // closed by close to stop run
+	running   sync.WaitGroup
+}
+
+// steering is nil when flow steering is disabled
+var steering *flowSteering
+
+func newFlowSteering(config Config, heavy *ebpf.Map) *flowSteering {
+	conf := config.Steering
+	if conf.Period == 0 {
+		conf.Period = INTERVAL * time.Second
+	}
+	if conf.ColdPPS > conf.HeavyPPS {
+		panic(fmt.Sprintf("cold rate %d must not exceed heavy rate %d", conf.ColdPPS, conf.HeavyPPS))
+	}
+	return &flowSteering{
+		conf:     conf,
+		iface:    config.Iface,
+		context:  config.RSSContext,
+		coreSet:  config.CoreSet,
+		heavy:    heavy,
+		counts:   make(map[bpfPkt5tuple]uint64),
+		flows:    make(map[bpfPkt5tuple]*steeredFlow),
+		lastPoll: time.Now(),
+		done:     make(chan struct{}),
+	}
+}
+
+// start runs update every Period in the background until close
+func (f *flowSteering) start() {
+	f.running.Add(1)
+	go f.run()
+}
+
+func (f *flowSteering) run() {
+	defer f.running.Done()
+	ticker := time.NewTicker(f.conf.Period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			f.update()
+		}
+	}
+}
+
+func flowString(rule NtupleRule) string {
+	return fmt.Sprintf("%d %s:%d -> %s:%d", rule.Proto, net.IP(rule.SrcIP[:]), binary.BigEndian.Uint16(rule.SrcPort[:]), net.IP(rule.DstIP[:]), binary.BigEndian.Uint16(rule.DstPort[:]))
+}
+
+// update reads the heavy hitters, steers the new elephants and releases the cold ones
+func (f *flowSteering) update() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	seconds := now.Sub(f.lastPoll).Seconds()
+	f.lastPoll = now
+
+	var key bpfPkt5tuple
+	var count uint64
+	seen := make(map[bpfPkt5tuple]bool)
+	iter := f.heavy.Iterate()
+	for iter.Next(&key, &count) {
+		seen[key] = true
+		last, known := f.counts[key]
+		f.counts[key] = count
+		// serve un secondo campione per stimare il rate
+		if !known || count < last || seconds <= 0 {
+			continue
+		}
+		rate := uint64(float64(count-last) / seconds)
+
+		if flow, ok := f.flows[key]; ok {
+			if rate >= f.conf.ColdPPS {
+				flow.lastHot = now
+			}
+			continue
+		}
+		if rate >= f.conf.HeavyPPS {
+			f.steer(key, rate, now)
+		}
+	}
+	// un panic qui salterebbe i cleanup, si riprova al prossimo giro
+	if err := iter.Err(); err != nil {
+		fmt.Printf("Reading the heavy hitters: %s\n", err)
+		return
+	}
+
+	// flussi rimossi dalla LRU
+	for key := range f.counts {
+		if !seen[key] {
+			delete(f.counts, key)
+		}
+	}
+	for key, flow := range f.flows {
+		if now.Sub(flow.lastHot) > f.conf.ColdAfter {
+			f.release(key, "cold")
+		}
+	}
+}
+
+func (f *flowSteering) freeLocation() (uint32, bool) {
+	for location := f.conf.FirstRule; location < f.conf.FirstRule+f.conf.MaxRules; location++ {
+		used := false
+		for _, flow := range f.flows {
+			if flow.rule.Location == location {
+				used = true
+				break
+			}
+		}
+		if !used {
+			return location, true
+		}
+	}
+	return 0, false
+}
+
+// pickQueue returns the next dedicated queue or the least loaded queue in the tuner's indirection table
+func (f *flowSteering) pickQueue() uint32 {
+	if len(f.conf.Queues) > 0 {
+		queue := f.conf.Queues[f.nextQueue%len(f.conf.Queues)]
+		f.nextQueue++
+		return queue
+	}
+
+	var queues []uint32
+	for _, queue := range getIndir(f.iface, f.context) {
+		if !slices.Contains(queues, queue) {
+			queues = append(queues, queue)
+		}
+	}
+	best := queues[0]
+	percentages, err := cpu.Percent(time.Second, true)
+	if err != nil {
+		fmt.Printf("Reading the CPU usage: %s, steering to queue %d\n", err, best)
+		return best
+	}
+
+	bestLoad := float64(101)
+	for _, queue := range queues {
+		if int(queue) >= len(f.coreSet) || f.coreSet[queue] >= len(percentages) {
+			continue
+		}
+		load := percentages[f.coreSet[queue]]
+		// ogni elefante gia' assegnato pesa sulla coda
+		for _, flow := range f.flows {
+			if flow.rule.Queue == queue {
+				load += 100 / float64(len(queues))
+			}
+		}
+		if load < bestLoad {
+			best = queue
+			bestLoad = load
+		}
+	}
+	return best
+}
+
+func (f *flowSteering) steer(key bpfPkt5tuple, rate uint64, now time.Time) {
+	p := message.NewPrinter(language.English)
+
+	location, ok := f.freeLocation()
+	if !ok {
+		p.Printf("Flow rule cap %d reached, not steering flow at %d pps\n", f.conf.MaxRules, rate)
+		return
+	}
+
+	rule := NtupleRule{
+		Location: location,
+		Proto:    key.Proto,
+		Queue:    f.pickQueue(),
+	}
+	binary.NativeEndian.PutUint32(rule.SrcIP[:], key.SrcIp)
+	binary.NativeEndian.PutUint32(rule.DstIP[:], key.DstIp)
+	binary.NativeEndian.PutUint16(rule.SrcPort[:], key.SrcPort)
+	binary.NativeEndian.PutUint16(rule.DstPort[:], key.DstPort)
+
+	if err := insertNtupleRule(f.iface, rule); err != nil {
+		p.Printf("Steering %s: %s\n", flowString(rule), err)
+		return
+	}
+	p.Printf("Heavy flow %s at %d pps pinned to queue %d (rule %d)\n", flowString(rule), rate, rule.Queue, rule.Location)
+	f.flows[key] = &steeredFlow{rule: rule, installed: now, lastHot: now}
+}
+
+func (f *flowSteering) release(key bpfPkt5tuple, reason string) {
+	flow := f.flows[key]
+	// la regola resta registrata, cosi' si riprova a toglierla
+	if err := deleteNtupleRule(f.iface, flow.rule.Location); err != nil {
+		fmt.Printf("Removing rule %d of flow %s: %s\n", flow.rule.Location, flowString(flow.rule), err)
+		return
+	}
+	fmt.Printf("Flow %s %s after %s, rule %d removed\n", flowString(flow.rule), reason, time.Since(flow.installed).Round(time.Second), flow.rule.Location)
+	delete(f.flows, key)
+}
+
+// releaseQueues removes the rules steering to queues that are about to disappear
+func (f *flowSteering) releaseQueues(from uint32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, flow := range f.flows {
+		if flow.rule.Queue >= from {
+			f.release(key, "moved off a removed queue")
+		}
+	}
+}
+
+// close stops run and then removes every rule installed by the tuner, so none is added back
+func (f *flowSteering) close() {
+	close(f.done)
+	f.running.Wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key := range f.flows {
+		f.release(key, "released on exit")
+	}
+}
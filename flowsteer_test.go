@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestFlowString(t *testing.T) {
+	rule := NtupleRule{Proto: 17, SrcIP: [4]byte{10, 0, 0, 1}, DstIP: [4]byte{192, 168, 1, 2}, SrcPort: [2]byte{0x1f, 0x90}, DstPort: [2]byte{0, 53}}
+	if got, want := flowString(rule), "17 10.0.0.1:8080 -> 192.168.1.2:53"; got != want {
+		t.Errorf("flowString = %q, want %q", got, want)
+	}
+}
+
+func TestFreeLocation(t *testing.T) {
+	steered := func(locations ...uint32) map[bpfPkt5tuple]*steeredFlow {
+		flows := make(map[bpfPkt5tuple]*steeredFlow)
+		for i, location := range locations {
+			flows[bpfPkt5tuple{SrcPort: uint16(i + 1)}] = &steeredFlow{rule: NtupleRule{Location: location}}
+		}
+		return flows
+	}
+	tests := []struct {
+		name   string
+		flows  map[bpfPkt5tuple]*steeredFlow
+		want   uint32
+		wantOK bool
+	}{
+		{"empty", steered(), 100, true},
+		{"hole", steered(100, 102), 101, true},
+		// solo le posizioni da FirstRule a FirstRule+MaxRules sono del tuner
+		{"full", steered(100, 101, 102), 0, false},
+	}
+	for _, test := range tests {
+		f := &flowSteering{conf: SteeringConfig{FirstRule: 100, MaxRules: 3}, flows: test.flows}
+		got, ok := f.freeLocation()
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("%s: freeLocation = %d, %t, want %d, %t", test.name, got, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestPickDedicatedQueue(t *testing.T) {
+	f := &flowSteering{conf: SteeringConfig{Queues: []uint32{4, 6}}}
+	// le code dedicate si usano a turno
+	for i, want := range []uint32{4, 6, 4} {
+		if got := f.pickQueue(); got != want {
+			t.Errorf("pickQueue %d = %d, want %d", i, got, want)
+		}
+	}
+}
@@ -18,7 +18,7 @@ require (
 require (
 	github.com/cilium/ebpf v0.17.3
 	github.com/shirou/gopsutil/v3 v3.24.5
-	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/sys v0.30.0
 )
 
 require (
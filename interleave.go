@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+)
+
+/*
+InterleaveConfig enables the A/B/A evaluation: instead of measuring the current value
+and then a neighbour, the two are alternated with short windows and compared in
+pairs, so a trend in the offered load hits both sides the same way.
+*/
+type InterleaveConfig struct {
+	Enabled      bool
+	Knobs        []string      // knobs evaluated this way, empty means all of them
+	Alternations int           // A/B pairs per neighbour
+	Window       time.Duration // length of every A and B window
+	Settle       time.Duration // wait after every flip before measuring
+}
+
+func interleaved(config Config, knob string) bool {
+	conf := config.Interleave
+	return conf.Enabled && (len(conf.Knobs) == 0 || slices.Contains(conf.Knobs, knob))
+}
+
+/*
+measureInterleaved alternates the knob between the values at indexes a and b, measuring
+a window after each flip, and returns the paired samples. The knob is left on b, flip
+is expected to wait Settle.
+*/
+func measureInterleaved(ethHandle *ethtool.Ethtool, config Config, flip func(Config, int) (Config, error), a int, b int) (Config, []Sample, []Sample, error) {
+	conf := config.Interleave
+	var samplesA, samplesB []Sample
+	for range max(conf.Alternations, 1) {
+		for _, index := range []int{a, b} {
+			next, err := flip(config, index)
+			if err != nil {
+				return config, nil, nil, err
+			}
+			config = next
+			sample := measureFor(ethHandle, config, conf.Window)
+			if index == a {
+				samplesA = append(samplesA, sample)
+			} else {
+				samplesB = append(samplesB, sample)
+			}
+		}
+	}
+	return config, samplesA, samplesB, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// cause of the packets received but not processed, from the driver counters
+const (
+	LOSS_RING     = "ring"     // rx_out_of_buffer: no descriptor posted, the cores are not keeping up
+	LOSS_NIC      = "nic"      // rx_discards_phy: NIC buffer full, PCIe or host memory back-pressure
+	LOSS_MISSED   = "missed"   // rx_missed_errors
+	LOSS_PRESSURE = "pressure" // rx_buffer_passed_thres_phy: NIC buffer above threshold, not dropped yet
+	LOSS_XDP      = "xdp"      // XDP_TX errors and full rings, the program itself is not the problem
+	LOSS_OTHER    = "other"    // not processed and not explained by a counter, e.g. XDP_PASS
+)
+
+// lossCounters are the driver counters every cause sums, missing ones count 0
+var lossCounters = map[string][]string{
+	LOSS_RING:     {"rx_out_of_buffer"},
+	LOSS_NIC:      {"rx_discards_phy"},
+	LOSS_MISSED:   {"rx_missed_errors"},
+	LOSS_PRESSURE: {"rx_buffer_passed_thres_phy"},
+	LOSS_XDP:      {"rx_xdp_tx_err", "rx_xdp_tx_full", "rx_xdp_aborted"},
+}
+
+// lossOrder is the order causes are listed in
+var lossOrder = []string{LOSS_RING, LOSS_NIC, LOSS_MISSED, LOSS_PRESSURE, LOSS_XDP, LOSS_OTHER}
+
+/*
+lossKnobs routes every cause to the knobs most likely to fix it: a ring running out of
+descriptors needs more ring, budget, cores or clock, a full NIC buffer needs less PCIe
+and memory traffic per packet.
+*/
+var lossKnobs = map[string][]string{
+	LOSS_RING:     {"rxqueue", "budget", "cores", "idle", "min_freq", "max_freq", "epp", "governor"},
+	LOSS_NIC:      {"cqe_compress", "striding", DDIO_KNOB, "l3_mask", "uncore_min_freq", "uncore_max_freq"},
+	LOSS_MISSED:   {"rxqueue", "budget"},
+	LOSS_PRESSURE: {"cqe_compress", "striding", DDIO_KNOB, "uncore_min_freq", "uncore_max_freq"},
+}
+
+// lossBreakdown returns the rate of every cause between two reads of the counters
+func lossBreakdown(pre map[string]uint64, post map[string]uint64, seconds float64, notProcessed int) map[string]uint64 {
+	loss := make(map[string]uint64)
+	var explained uint64
+	for cause, counters := range lossCounters {
+		var total uint64
+		for _, counter := range counters {
+			// un reset lo scarta checkCounters, qui vale 0
+			delta, _ := counterDelta(pre[counter], post[counter])
+			total += delta
+		}
+		loss[cause] = uint64(float64(total) / seconds)
+		// sopra la soglia i pacchetti arrivano ancora, non sono persi
+		if cause != LOSS_PRESSURE {
+			explained += loss[cause]
+		}
+	}
+	if notProcessed > 0 && uint64(notProcessed) > explained {
+		loss[LOSS_OTHER] = uint64(notProcessed) - explained
+	}
+	return loss
+}
+
+// dominantLoss returns the cause with the highest rate that a knob can act on, "" without loss
+func dominantLoss(loss map[string]uint64) string {
+	var dominant string
+	for _, cause := range lossOrder {
+		if _, ok := lossKnobs[cause]; ok && loss[cause] > 0 && (dominant == "" || loss[cause] > loss[dominant]) {
+			dominant = cause
+		}
+	}
+	return dominant
+}
+
+// lossString formats the non zero causes for the CSV
+func lossString(loss map[string]uint64) string {
+	var causes []string
+	for _, cause := range lossOrder {
+		if loss[cause] > 0 {
+			causes = append(causes, fmt.Sprintf("%s=%d", cause, loss[cause]))
+		}
+	}
+	return strings.Join(causes, ";")
+}
+
+// tuningStep tunes one knob, knob is the name lossKnobs refers to
+type tuningStep struct {
+	knob   string
+	change func(config Config, extDrop uint64) (Config, Sample)
+}
+
+// routeLoss moves the steps acting on the dominant cause of the last sample first, the others keep their order
+func routeLoss(steps []tuningStep, sample Sample) []tuningStep {
+	cause := dominantLoss(sample.Loss)
+	if cause == "" {
+		return steps
+	}
+	fmt.Printf("Loss mostly %s (%s), tuning %s first\n", cause, lossString(sample.Loss), strings.Join(lossKnobs[cause], ", "))
+	routed := slices.Clone(steps)
+	slices.SortStableFunc(routed, func(a tuningStep, b tuningStep) int {
+		inA, inB := slices.Contains(lossKnobs[cause], a.knob), slices.Contains(lossKnobs[cause], b.knob)
+		switch {
+		case inA && !inB:
+			return -1
+		case inB && !inA:
+			return 1
+		}
+		return 0
+	})
+	return routed
+}
@@ -3,9 +3,8 @@ package main
 import (
 	"C"
 	"encoding/csv"
+	"flag"
 	"fmt"
-	"log"
-	"math"
 	"net"
 	"os"
 	"slices"
@@ -13,8 +12,6 @@ import (
 
 	"github.com/VladimiroPaschali/ethtool-indir"
 	"github.com/cilium/ebpf/link"
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/u-root/u-root/pkg/msr"
 
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
@@ -23,21 +20,41 @@ import (
 // var PPS_THRESHOLD float64 = 1.0001 // 7 mila su 7 milioni
 const PPS_THRESHOLD float64 = 1 // 7 mila su 7 milioni
 const DROPPED_THRESHOLD = 100
-const MAX_CORES = 32
-const MAX_INDIR_SIZE = 256
 const INTERVAL = 5
 
 type Config struct {
-	Iface       string
-	Action      string
-	Budget      uint32
-	RXQueue     uint32
-	CQECompress bool
-	Striding    bool
-	Weight      [MAX_CORES]uint32
-	Cores       uint32
-	MSR         uint32
-	MSRValue    uint64
+	Iface        string
+	Action       string
+	Budget       uint32
+	RXQueue      uint32
+	CQECompress  bool
+	Striding     bool
+	Weight       []uint32 // RSS weight of every channel
+	Cores        uint32
+	MSRKnobs     []MSRKnob
+	MSRValues    map[string]uint64 // current value of every MSR knob, cloned before changes
+	Scaling      ScalingConfig
+	CPUList      string // RX cores, empty selects the NIC's NUMA node
+	SMT          bool   // allow both hardware threads of a core
+	CoreSet      []int  // CPU of every RX queue, in queue order
+	Steering     SteeringConfig
+	Objective    ObjectiveConfig
+	Constraints  Constraints
+	Significance SignificanceConfig
+	Window       WindowConfig
+	Interleave   InterleaveConfig
+	Settle       SettleConfig
+	Search       SearchConfig
+	Bandit       BanditConfig
+	RSSContext   uint32             // RSS context owned by the tuner, 0 is the default one
+	Contexts     []RSSContextConfig // extra contexts for other workloads, never touched by the tuner
+	Resctrl      ResctrlConfig
+	L3Mask       uint64 // L3 CAT mask of the RX cores
+	CPUFreq      CPUFreqConfig
+	SysfsKnobs   []SysfsKnob
+	SysfsValues  map[string]string // current value of every sysfs knob, cloned before changes
+	Idle         IdleConfig
+	IdleLimit    int // deepest idle state allowed on the RX cores
 }
 
 //go:generate go run github.com/cilium/ebpf/cmd/bpf2go -tags linux bpf cms.bpf.c
@@ -81,44 +98,34 @@ func setConfig(ethHandle *ethtool.Ethtool, config Config) {
 	ring.RxPending = config.RXQueue
 	ring = setRing(ethHandle, config.Iface, ring)
 
-}
-func setMSR(val uint64) {
-	c, err := msr.AllCPUs()
-	if err != nil {
-		panic(err)
-	}
-	var r msr.MSR = 0xc8b
-	r.Write(c, val)
-	// fmt.Printf("Set MSR to %x\n", val)
-
 }
 
-func getIndir(ethHandle *ethtool.Ethtool, iface string) [256]uint32 {
-	indir, err := ethHandle.GetIndir(iface)
+func getIndir(iface string, context uint32) []uint32 {
+	rss, err := getRSS(iface, context)
 	if err != nil {
 		panic(err.Error())
 	}
-	return indir.RingIndex
+	return rss.Indir
 }
 
-func setIndir(ethHandle *ethtool.Ethtool, config Config) {
-	setindir := ethtool.SetIndir{}
-	setindir.Weight = config.Weight[:]
-	_, err := ethHandle.SetIndir(config.Iface, setindir)
+func setIndir(config Config) {
+	size := uint32(len(getIndir(config.Iface, config.RSSContext)))
+	indir, err := fillIndir(size, config.Weight)
 	if err != nil {
 		panic(err.Error())
 	}
+	overrideIndir(config.Iface, config.RSSContext, indir)
 }
 
-func overrideIndir(ethHandle *ethtool.Ethtool, iface string, indir ethtool.SetIndir) {
-	_, err := ethHandle.SetIndir(iface, indir)
+func overrideIndir(iface string, context uint32, indir []uint32) {
+	_, err := setRSS(iface, RSS{Context: context, Indir: indir})
 	if err != nil {
 		panic(err.Error())
 	}
 }
 
-func equalizeIndir(ethHandle *ethtool.Ethtool, config Config, minCPU uint32, maxCPU uint32) {
-	oldIndir := getIndir(ethHandle, config.Iface)
+func equalizeIndir(config Config, minCPU uint32, maxCPU uint32) {
+	oldIndir := getIndir(config.Iface, config.RSSContext)
 	for index, value := range oldIndir {
 		// if value == maxCPU && index%2 == 0 {
 		if value == maxCPU && index%5 == 0 {
@@ -126,70 +133,7 @@ func equalizeIndir(ethHandle *ethtool.Ethtool, config Config, minCPU uint32, max
 			oldIndir[index] = minCPU
 		}
 	}
-	// variabile indir
-	newIndir := ethtool.SetIndir{}
-	newIndir.RingIndex = oldIndir
-	overrideIndir(ethHandle, config.Iface, newIndir)
-}
-
-func getAction(ethHandle *ethtool.Ethtool, iface string, seconds int, action string) uint64 {
-
-	var duration time.Duration = time.Duration(seconds) * time.Second
-
-	stats, err := ethHandle.Stats(iface)
-	if err != nil {
-		panic(err.Error())
-	}
-	// fmt.Printf("rx_xdp_drop: %d\n", stats["rx_xdp_drop"])
-	var pre uint64 = stats["rx_xdp_drop"]
-
-	time.Sleep(duration)
-
-	stats, err = ethHandle.Stats(iface)
-	if err != nil {
-		panic(err.Error())
-	}
-	// fmt.Printf("rx_xdp_drop: %d\n", stats["rx_xdp_drop"])
-	var post uint64 = stats["rx_xdp_drop"]
-	var tot uint64 = post - pre
-	var pps uint64 = tot / uint64(seconds)
-
-	// p := message.NewPrinter(language.English)
-	// p.Printf("Drop per second %d\n", pps)
-
-	return pps
-}
-
-func getNotProcessed(ethHandle *ethtool.Ethtool, iface string, seconds int, action string) int {
-	var duration time.Duration = time.Duration(seconds) * time.Second
-
-	stats, err := ethHandle.Stats(iface)
-	if err != nil {
-		panic(err.Error())
-	}
-	// fmt.Printf("rx_xdp_drop: %d\n", stats["rx_xdp_drop"])
-	var prePhy uint64 = stats["rx_packets_phy"]
-	var preAction uint64 = stats[action]
-
-	time.Sleep(duration)
-
-	stats, err = ethHandle.Stats(iface)
-	if err != nil {
-		panic(err.Error())
-	}
-	// fmt.Printf("rx_xdp_drop: %d\n", stats["rx_xdp_drop"])
-	var postPhy uint64 = stats["rx_packets_phy"]
-	var postAction uint64 = stats[action]
-	var totPhy uint64 = postPhy - prePhy
-	var totAction uint64 = postAction - preAction
-	var ppsPhy uint64 = totPhy / uint64(seconds)
-	var ppsAction uint64 = totAction / uint64(seconds)
-	var pps uint64 = ppsPhy - ppsAction
-
-	// p := message.NewPrinter(language.English)
-	// p.Printf("Not Processed per second %d\n", int(pps))
-
-	return int(pps)
+	overrideIndir(config.Iface, config.RSSContext, oldIndir)
 }
 
 func createCSV() *csv.Writer {
@@ -200,7 +144,7 @@ func createCSV() *csv.Writer {
 
 	writer := csv.NewWriter(file)
 
-	err = writer.Write([]string{"budget", "rxqueue", "rx_cqe_compress", "rx_striding_rq", "rx_xdp_drop", "msr", "cpu", "core_count", "time"})
+	err = writer.Write([]string{"budget", "rxqueue", "rx_cqe_compress", "rx_striding_rq", "rx_xdp_drop", "rx_packets_phy", "msr", "sysfs", "idle", "ddio_ways", "l3_ways", "llc_occupancy_kb", "mbm_mbps", "watts", "joules_per_mpkt", "cpu", "cpu_per_mpps", "loss", "core_count", "time"})
 	if err != nil {
 		file.Close()
 		panic(err.Error())
@@ -211,12 +155,13 @@ func createCSV() *csv.Writer {
 	return writer
 }
 
-func writeCSV(writer *csv.Writer, config Config, drop uint64, cpu float64) {
+func writeCSV(writer *csv.Writer, config Config, sample Sample) {
 	now := time.Now()
+	l3Ways, llcOccupancy, mbm := resctrlStrings(config, sample)
 	p := message.NewPrinter(language.English)
-	p.Printf("budget: %d, rxqueue: %d, cqe_compress: %t, striding: %t, drop: %d, msr: %x cpu: %f, core_count %d, time: %s\n", config.Budget, config.RXQueue, config.CQECompress, config.Striding, drop, config.MSRValue, cpu, config.Cores, now.Format("15:04:05"))
+	p.Printf("budget: %d, rxqueue: %d, cqe_compress: %t, striding: %t, drop: %d, input: %d, msr: %s, sysfs: %s, idle: %s, ddio_ways: %s, l3_ways: %s, llc_kb: %s, mbm: %s, watts: %f, joules_per_mpkt: %f, cpu: %f, cpu_per_mpps: %f, loss: %s, core_count %d, time: %s\n", config.Budget, config.RXQueue, config.CQECompress, config.Striding, sample.PPS, sample.InputPPS, msrString(config), sysfsString(config), idleString(config), ddioString(config), l3Ways, llcOccupancy, mbm, sample.Watts, sample.Energy, sample.CPU, sample.cpuPerMpps(), lossString(sample.Loss), config.Cores, now.Format("15:04:05"))
 
-	err := writer.Write([]string{fmt.Sprintf("%d", config.Budget), fmt.Sprintf("%d", config.RXQueue), fmt.Sprintf("%t", config.CQECompress), fmt.Sprintf("%t", config.Striding), fmt.Sprintf("%d", drop), fmt.Sprintf("%x", config.MSRValue), fmt.Sprintf("%f", cpu), fmt.Sprintf("%d", config.Cores), now.Format("15:04:05")})
+	err := writer.Write([]string{fmt.Sprintf("%d", config.Budget), fmt.Sprintf("%d", config.RXQueue), fmt.Sprintf("%t", config.CQECompress), fmt.Sprintf("%t", config.Striding), fmt.Sprintf("%d", sample.PPS), fmt.Sprintf("%d", sample.InputPPS), msrString(config), sysfsString(config), idleString(config), ddioString(config), l3Ways, llcOccupancy, mbm, fmt.Sprintf("%f", sample.Watts), fmt.Sprintf("%f", sample.Energy), fmt.Sprintf("%f", sample.CPU), fmt.Sprintf("%f", sample.cpuPerMpps()), lossString(sample.Loss), fmt.Sprintf("%d", config.Cores), now.Format("15:04:05")})
 	if err != nil {
 		panic(err.Error())
 	}
@@ -224,19 +169,91 @@ func writeCSV(writer *csv.Writer, config Config, drop uint64, cpu float64) {
 
 }
 
+// tuningSteps lists the enabled knobs in the order a round tunes them
+func tuningSteps(ethHandle *ethtool.Ethtool, config Config, scaler *coreScaler) []tuningStep {
+	steps := []tuningStep{
+		{"rxqueue", func(config Config, extDrop uint64) (Config, Sample) {
+			return changeRxQueue(ethHandle, config, INTERVAL, extDrop)
+		}},
+		{"budget", func(config Config, extDrop uint64) (Config, Sample) {
+			return changeRxBudget(ethHandle, config, INTERVAL, extDrop)
+		}},
+		{"cqe_compress", func(config Config, extDrop uint64) (Config, Sample) {
+			return changeCqeCompress(ethHandle, config, INTERVAL, extDrop)
+		}},
+		{"striding", func(config Config, extDrop uint64) (Config, Sample) {
+			return changeRxStriding(ethHandle, config, INTERVAL, extDrop)
+		}},
+		{"cores", func(config Config, extDrop uint64) (Config, Sample) {
+			return scaler.changeCPUCount(ethHandle, config, INTERVAL, extDrop)
+		}},
+	}
+
+	for _, knob := range config.MSRKnobs {
+		steps = append(steps, tuningStep{knob.Name, func(config Config, extDrop uint64) (Config, Sample) {
+			return changeMSR(ethHandle, config, knob, INTERVAL, extDrop)
+		}})
+	}
+
+	for _, knob := range config.SysfsKnobs {
+		steps = append(steps, tuningStep{knob.Name, func(config Config, extDrop uint64) (Config, Sample) {
+			return changeSysfs(ethHandle, config, knob, INTERVAL, extDrop)
+		}})
+	}
+
+	if idleCtl != nil {
+		steps = append(steps, tuningStep{"idle", func(config Config, extDrop uint64) (Config, Sample) {
+			return idleCtl.changeIdleLimit(ethHandle, config, INTERVAL, extDrop)
+		}})
+	}
+
+	if cacheAlloc != nil {
+		steps = append(steps, tuningStep{"l3_mask", func(config Config, extDrop uint64) (Config, Sample) {
+			return cacheAlloc.changeL3Mask(ethHandle, config, INTERVAL, extDrop)
+		}})
+	}
+	return steps
+}
+
+// searchSpace lists the enabled knobs as the dimensions of the joint search
+func searchSpace(ethHandle *ethtool.Ethtool, config Config, scaler *coreScaler) []Dimension {
+	space := []Dimension{
+		rxQueueDimension(ethHandle, config),
+		budgetDimension(ethHandle, config),
+		cqeCompressDimension(ethHandle, config),
+		stridingDimension(ethHandle, config),
+		scaler.dimension(ethHandle, config),
+	}
+	for _, knob := range config.MSRKnobs {
+		space = append(space, msrDimension(config, knob))
+	}
+	for _, knob := range config.SysfsKnobs {
+		space = append(space, sysfsDimension(config, knob))
+	}
+	if idleCtl != nil {
+		space = append(space, idleCtl.dimension(config))
+	}
+	if cacheAlloc != nil {
+		space = append(space, cacheAlloc.dimension(config))
+	}
+	// un knob con un solo valore non c'e' niente da cercare
+	return slices.DeleteFunc(space, func(d Dimension) bool { return len(d.Values) < 2 })
+}
+
 // attachXDP carica e attacca il programma XDP all'interfaccia specificata
-func attachXDP(iface string) link.Link {
+func attachXDP(iface string) (link.Link, *bpfObjects) {
 
 	// Load pre-compiled programs into the kernel.
-	objs := bpfObjects{}
-	if err := loadBpfObjects(&objs, nil); err != nil {
-		log.Fatalf("loading objects: %s", err)
+	// the maps stay open, the heavy hitters are read while tuning
+	objs := &bpfObjects{}
+	if err := loadBpfObjects(objs, nil); err != nil {
+		fatalf("loading objects: %s", err)
 	}
-	defer objs.Close()
 
 	ifnum, err := net.InterfaceByName(iface)
 	if err != nil {
-		log.Fatalf("Getting interface %s: %s", iface, err)
+		objs.Close()
+		fatalf("Getting interface %s: %s", iface, err)
 	}
 
 	// Attach count_packets to the network interface.
@@ -245,531 +262,116 @@ func attachXDP(iface string) link.Link {
 		Interface: ifnum.Index,
 	})
 	if err != nil {
-		log.Fatal("Attaching XDP:", err)
+		objs.Close()
+		fatalf("Attaching XDP: %s", err)
 	}
 
 	fmt.Printf("Programma XDP attaccato a %s\n", iface)
-	return xdpLink
+	return xdpLink, objs
 }
 
-func getCPUPercentage(core int) int {
-	percentages, err := cpu.Percent(time.Second, true)
-	if err != nil {
-		panic(err.Error())
-	}
-	fmt.Printf("CPU %d usage: %d\n", core, int(math.Round(percentages[core])))
-	return int(math.Round(percentages[core]))
-}
+// rxQueueDimension is the RX ring size as searched
+func rxQueueDimension(ethHandle *ethtool.Ethtool, config Config) Dimension {
+	var listRxQueue = []uint32{128, 256, 512, 1024, 2048, 4096, 8192}
 
-func getAverageCPUPercentage(weight [MAX_CORES]uint32) float64 {
-	percentages, err := cpu.Percent(time.Second, true)
-	if err != nil {
-		panic(err.Error())
-	}
-	var sum float64
-	var numcores int
-	for index, p := range percentages {
-		if weight[index] > 0 {
-			// fmt.Printf("CPU %d usage: %d\n", index, int(math.Round(p)))
-			sum += p
-			numcores++
-		}
-	}
-	// avg := int(math.Round(sum / float64(numcores)))
-	avg := sum / float64(numcores)
-	// fmt.Printf("CPU usage: %v\n", percentages)
-	// fmt.Printf("Average CPU usage: %d\n", avg)
-	return avg
+	return Dimension{"rxqueue", formatValues(listRxQueue, "%d"), slices.Index(listRxQueue, config.RXQueue), fmt.Sprintf("%d", config.RXQueue), 10, func(config Config, index int) (Config, error) {
+		config.RXQueue = listRxQueue[index]
+		setConfig(ethHandle, config)
+		return config, nil
+	}}
 }
 
 /*
 test and update the current RX Queue size if there is a gain in throughput
 */
-func changeRxQueue(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, uint64, float64) {
-	var listRxQueue = []uint32{128, 256, 512, 1024, 2048, 4096, 8192}
-
-	oldPPS := getAction(ethHandle, config.Iface, interval, config.Action)
-	oldCPU := getAverageCPUPercentage(config.Weight)
-	oldNotProcessed := getNotProcessed(ethHandle, config.Iface, interval, config.Action)
-
-	var nextPPS uint64
-	var nextCPU float64
-	var nextNotProcessed int
-	var prevPPS uint64
-	var prevCPU float64
-	var prevNotProcessed int
-	var bestPPS uint64
-	var bestCPU float64
-
-	oldRxQueueIndex := slices.Index(listRxQueue, config.RXQueue)
-	prevRxQueueIndex := oldRxQueueIndex - 1
-	nexRxQueueIndex := oldRxQueueIndex + 1
-
-	//gathers data for the different RXQueue sizes
-	if prevRxQueueIndex >= 0 {
-		config.RXQueue = listRxQueue[prevRxQueueIndex]
-		setConfig(ethHandle, config)
-		prevPPS = getAction(ethHandle, config.Iface, interval, config.Action)
-		prevCPU = getAverageCPUPercentage(config.Weight)
-		prevNotProcessed = getNotProcessed(ethHandle, config.Iface, interval, config.Action)
-	}
-	if nexRxQueueIndex <= len(listRxQueue) {
-
-		config.RXQueue = listRxQueue[nexRxQueueIndex]
-		setConfig(ethHandle, config)
-		nextPPS = getAction(ethHandle, config.Iface, interval, config.Action)
-		nextCPU = getAverageCPUPercentage(config.Weight)
-		nextNotProcessed = getNotProcessed(ethHandle, config.Iface, interval, config.Action)
-	}
-
-	// fmt.Printf("Old %d, Next %d, Prev %d, extern %d\n", old, next, prev, extDrop)
-
-	p := message.NewPrinter(language.English)
-	type candidate struct {
-		rxQueueIndex int
-		pps          uint64
-		cpu          float64
-		name         string
+func changeRxQueue(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, Sample) {
+	config, sample, err := searchNeighbours(ethHandle, config, interval, extDrop, rxQueueDimension(ethHandle, config))
+	if err != nil {
+		panic(err.Error())
 	}
+	return config, sample
+}
 
-	var candidates []candidate
-
-	// Raccogli solo le configurazioni che processano tutto
-	if oldNotProcessed < DROPPED_THRESHOLD {
-		candidates = append(candidates, candidate{oldRxQueueIndex, oldPPS, oldCPU, "Old"})
-	}
-	if prevNotProcessed < DROPPED_THRESHOLD {
-		candidates = append(candidates, candidate{prevRxQueueIndex, prevPPS, prevCPU, "Prev"})
-	}
-	if nextNotProcessed < DROPPED_THRESHOLD {
-		candidates = append(candidates, candidate{nexRxQueueIndex, nextPPS, nextCPU, "Next"})
-	}
+// budgetDimension is the NAPI budget as searched
+func budgetDimension(ethHandle *ethtool.Ethtool, config Config) Dimension {
+	var listBudget = []uint32{2, 4, 8, 16, 32, 64, 128, 256, 512}
 
-	if len(candidates) > 0 {
-		// Scegli quella con minore CPU usage
-		best := candidates[0]
-		for _, c := range candidates[1:] {
-			if c.cpu < best.cpu {
-				best = c
-			}
-		}
-		p.Printf("%s RXQueue %d is best (CPU=%f) by (CPU=%f) \n", best.name, listRxQueue[best.rxQueueIndex], best.cpu, oldCPU-best.cpu)
-		// p.Printf("%s RXQueue %d is best (CPU=%f) (PPS=%d) by (CPU=%f) (PPS=%d) \n", best.name, listRxQueue[best.rxQueueIndex], best.cpu, best.pps, oldCPU-best.cpu, int(best.pps)-int(oldPPS))
-		config.RXQueue = listRxQueue[best.rxQueueIndex]
+	return Dimension{"budget", formatValues(listBudget, "%d"), slices.Index(listBudget, config.Budget), fmt.Sprintf("%d", config.Budget), 10, func(config Config, index int) (Config, error) {
+		config.Budget = listBudget[index]
 		setConfig(ethHandle, config)
-		bestPPS = best.pps
-		bestCPU = best.cpu
-	} else {
-		// Nessuna configurazione processa tutto -> massimizza il throughput
-		p.Printf("Not all processed, looking for higher throughput\n")
-		if float64(prevPPS) > float64(nextPPS)*PPS_THRESHOLD && float64(prevPPS) > float64(oldPPS)*PPS_THRESHOLD && float64(prevPPS) > float64(extDrop)*PPS_THRESHOLD {
-			p.Printf("Lower RXQueue %d is better by (PPS=%d)\n", listRxQueue[prevRxQueueIndex], prevPPS-oldPPS)
-			config.RXQueue = listRxQueue[prevRxQueueIndex]
-			bestPPS = prevPPS
-			bestCPU = prevCPU
-		} else if float64(nextPPS) > float64(prevPPS)*PPS_THRESHOLD && float64(nextPPS) > float64(oldPPS)*PPS_THRESHOLD && float64(nextPPS) > float64(extDrop)*PPS_THRESHOLD {
-			p.Printf("Higher RXQueue %d is better by (PPS=%d)\n", listRxQueue[nexRxQueueIndex], nextPPS-oldPPS)
-			config.RXQueue = listRxQueue[nexRxQueueIndex]
-			bestPPS = nextPPS
-			bestCPU = nextCPU
-		} else {
-			p.Printf("Current RXQueue %d is better\n", listRxQueue[oldRxQueueIndex])
-			config.RXQueue = listRxQueue[oldRxQueueIndex]
-			bestPPS = oldPPS
-			bestCPU = oldCPU
-		}
-		setConfig(ethHandle, config)
-	}
-
-	return config, bestPPS, bestCPU
-
+		return config, nil
+	}}
 }
 
 /*
 test and update the current RX budget size if there is a gain in throughput
 */
-func changeRxBudget(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, uint64, float64) {
-	var listBudget = []uint32{2, 4, 8, 16, 32, 64, 128, 256, 512}
-
-	oldPPS := getAction(ethHandle, config.Iface, interval, config.Action)
-	oldCPU := getAverageCPUPercentage(config.Weight)
-	oldNotProcessed := getNotProcessed(ethHandle, config.Iface, interval, config.Action)
-
-	var nextPPS uint64
-	var nextCPU float64
-	var nextNotProcessed int
-	var prevPPS uint64
-	var prevCPU float64
-	var prevNotProcessed int
-	var bestPPS uint64
-	var bestCPU float64
-
-	oldBudgetIndex := slices.Index(listBudget, config.Budget)
-	prevBudgetIndex := oldBudgetIndex - 1
-	nexBudgetIndex := oldBudgetIndex + 1
-
-	//gathers data for the different budget values
-	if prevBudgetIndex > 0 {
-		config.Budget = listBudget[prevBudgetIndex]
-		setConfig(ethHandle, config)
-		prevPPS = getAction(ethHandle, config.Iface, interval, config.Action)
-		prevCPU = getAverageCPUPercentage(config.Weight)
-		prevNotProcessed = getNotProcessed(ethHandle, config.Iface, interval, config.Action)
-	}
-	if nexBudgetIndex < len(listBudget) {
-		config.Budget = listBudget[nexBudgetIndex]
-		setConfig(ethHandle, config)
-		nextPPS = getAction(ethHandle, config.Iface, interval, config.Action)
-		nextCPU = getAverageCPUPercentage(config.Weight)
-		nextNotProcessed = getNotProcessed(ethHandle, config.Iface, interval, config.Action)
-	}
-	p := message.NewPrinter(language.English)
-	// fmt.Printf("Old %d, Next %d, Prev %d, extern %d\n", old, next, prev, extDrop)
-
-	type candidate struct {
-		budgetIndex int
-		pps         uint64
-		cpu         float64
-		name        string
-	}
-	var candidates []candidate
-	// Raccogli solo le configurazioni che processano tutto
-	if oldNotProcessed < DROPPED_THRESHOLD {
-		candidates = append(candidates, candidate{oldBudgetIndex, oldPPS, oldCPU, "Old"})
-	}
-	if prevNotProcessed < DROPPED_THRESHOLD {
-		candidates = append(candidates, candidate{prevBudgetIndex, prevPPS, prevCPU, "Prev"})
-	}
-	if nextNotProcessed < DROPPED_THRESHOLD {
-		candidates = append(candidates, candidate{nexBudgetIndex, nextPPS, nextCPU, "Next"})
-	}
-	if len(candidates) > 0 {
-		// Scegli quella con minore CPU usage
-		best := candidates[0]
-		for _, c := range candidates[1:] {
-			if c.cpu < best.cpu {
-				best = c
-			}
-		}
-		p.Printf("%s Budget %d is best (CPU=%f) by (CPU=%f) \n", best.name, listBudget[best.budgetIndex], best.cpu, oldCPU-best.cpu)
-		// p.Printf("%s Budget %d is best (CPU=%f) (PPS=%d) by (CPU=%f) (PPS=%d) \n", best.name, listBudget[best.budgetIndex], best.cpu, best.pps, oldCPU-best.cpu, int(best.pps)-int(oldPPS))
-		config.Budget = listBudget[best.budgetIndex]
-		setConfig(ethHandle, config)
-		bestPPS = best.pps
-		bestCPU = best.cpu
-	} else {
-		// Nessuna configurazione processa tutto -> massimizza il throughput
-		p.Printf("Not all processed, looking for higher throughput\n")
-		if float64(prevPPS) > float64(nextPPS)*PPS_THRESHOLD && float64(prevPPS) > float64(oldPPS)*PPS_THRESHOLD && float64(prevPPS) > float64(extDrop)*PPS_THRESHOLD {
-			p.Printf("Lower Budget %d is better by (PPS=%d)\n", listBudget[prevBudgetIndex], prevPPS-oldPPS)
-			config.Budget = listBudget[prevBudgetIndex]
-			bestPPS = prevPPS
-			bestCPU = prevCPU
-		} else if float64(nextPPS) > float64(prevPPS)*PPS_THRESHOLD && float64(nextPPS) > float64(oldPPS)*PPS_THRESHOLD && float64(nextPPS) > float64(extDrop)*PPS_THRESHOLD {
-			p.Printf("Higher Budget %d is better by (PPS=%d)\n", listBudget[nexBudgetIndex], nextPPS-oldPPS)
-			config.Budget = listBudget[nexBudgetIndex]
-			bestPPS = nextPPS
-			bestCPU = nextCPU
-		} else {
-			p.Printf("Current Budget %d is better\n", listBudget[oldBudgetIndex])
-			config.Budget = listBudget[oldBudgetIndex]
-			bestPPS = oldPPS
-			bestCPU = oldCPU
-		}
-		setConfig(ethHandle, config)
+func changeRxBudget(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, Sample) {
+	config, sample, err := searchNeighbours(ethHandle, config, interval, extDrop, budgetDimension(ethHandle, config))
+	if err != nil {
+		panic(err.Error())
 	}
-	return config, bestPPS, bestCPU
+	return config, sample
 }
 
-func changeCqeCompress(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, uint64, float64) {
-
-	oldPPS := getAction(ethHandle, config.Iface, interval, config.Action)
-	oldCPU := getAverageCPUPercentage(config.Weight)
-	oldNotProcessed := getNotProcessed(ethHandle, config.Iface, interval, config.Action)
-
-	oldCQECompress := config.CQECompress
-	newCQECompress := !oldCQECompress
-
-	var bestPPS uint64
-	var bestCPU float64
-
-	config.CQECompress = newCQECompress
-	setConfig(ethHandle, config)
-
-	newPPS := getAction(ethHandle, config.Iface, interval, config.Action)
-	newCPU := getAverageCPUPercentage(config.Weight)
-	newNotProcessed := getNotProcessed(ethHandle, config.Iface, interval, config.Action)
-	p := message.NewPrinter(language.English)
-
-	type candidate struct {
-		cqeCompress bool
-		pps         uint64
-		cpu         float64
-		name        string
-	}
-
-	var candidates []candidate
+func cqeCompressDimension(ethHandle *ethtool.Ethtool, config Config) Dimension {
+	var listCQECompress = []bool{false, true}
 
-	// Considera solo quelle che processano tutto
-	if oldNotProcessed < DROPPED_THRESHOLD {
-		candidates = append(candidates, candidate{oldCQECompress, oldPPS, oldCPU, "Old"})
-	}
-	if newNotProcessed < DROPPED_THRESHOLD {
-		candidates = append(candidates, candidate{newCQECompress, newPPS, newCPU, "New"})
-	}
-
-	if len(candidates) > 0 {
-		// Tra quelle valide, scegli la meno pesante in termini di CPU
-		best := candidates[0]
-		for _, c := range candidates[1:] {
-			if c.cpu < best.cpu {
-				best = c
-			}
-		}
-		p.Printf("%s CQE Compression %t is best (CPU=%f) by (CPU=%f)\n", best.name, best.cqeCompress, best.cpu, oldCPU-best.cpu)
-		config.CQECompress = best.cqeCompress
+	return Dimension{"cqe_compress", formatValues(listCQECompress, "%t"), slices.Index(listCQECompress, config.CQECompress), fmt.Sprintf("%t", config.CQECompress), 10, func(config Config, index int) (Config, error) {
+		config.CQECompress = listCQECompress[index]
 		setConfig(ethHandle, config)
-		bestPPS = best.pps
-		bestCPU = best.cpu
-	} else {
-		// Nessuna delle due processa tutto → massimizza il throughput
-		p.Printf("Not all processed, looking for higher throughput\n")
-		if float64(newPPS) > float64(oldPPS)*PPS_THRESHOLD && float64(newPPS) > float64(extDrop)*PPS_THRESHOLD {
-			p.Printf("New CQE Compression %t is better by (PPS=%d)\n", newCQECompress, newPPS-oldPPS)
-			config.CQECompress = newCQECompress
-			bestPPS = newPPS
-			bestCPU = newCPU
-		} else {
-			p.Printf("Previous CQE Compression %t was better by (PPS=%d), reverting\n", oldCQECompress, oldPPS-newPPS)
-			config.CQECompress = oldCQECompress
-			bestPPS = oldPPS
-			bestCPU = oldCPU
-		}
-		setConfig(ethHandle, config)
-	}
-	return config, bestPPS, bestCPU
+		return config, nil
+	}}
 }
 
-func changeRxStriding(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, uint64, float64) {
-
-	oldPPS := getAction(ethHandle, config.Iface, interval, config.Action)
-	oldCPU := getAverageCPUPercentage(config.Weight)
-	oldNotProcessed := getNotProcessed(ethHandle, config.Iface, interval, config.Action)
-
-	oldRxStriding := config.Striding
-	newRxStriding := !oldRxStriding
-	var bestPPS uint64
-	var bestCPU float64
-
-	config.Striding = newRxStriding
-	setConfig(ethHandle, config)
-
-	newPPS := getAction(ethHandle, config.Iface, interval, config.Action)
-	newCPU := getAverageCPUPercentage(config.Weight)
-	newNotProcessed := getNotProcessed(ethHandle, config.Iface, interval, config.Action)
-	p := message.NewPrinter(language.English)
-	type candidate struct {
-		rxStriding bool
-		pps        uint64
-		cpu        float64
-		name       string
+func changeCqeCompress(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, Sample) {
+	config, sample, err := searchNeighbours(ethHandle, config, interval, extDrop, cqeCompressDimension(ethHandle, config))
+	if err != nil {
+		panic(err.Error())
 	}
+	return config, sample
+}
 
-	var candidates []candidate
-
-	// Considera solo le configurazioni che processano tutto
-	if oldNotProcessed < DROPPED_THRESHOLD {
-		candidates = append(candidates, candidate{oldRxStriding, oldPPS, oldCPU, "Old"})
-	}
-	if newNotProcessed < DROPPED_THRESHOLD {
-		candidates = append(candidates, candidate{newRxStriding, newPPS, newCPU, "New"})
-	}
+func stridingDimension(ethHandle *ethtool.Ethtool, config Config) Dimension {
+	var listStriding = []bool{false, true}
 
-	if len(candidates) > 0 {
-		// Tra le valide, scegli quella con minore utilizzo CPU
-		best := candidates[0]
-		for _, c := range candidates[1:] {
-			if c.cpu < best.cpu {
-				best = c
-			}
-		}
-		p.Printf("%s Rx Striding %t is best (CPU=%f) by (CPU=%f)\n", best.name, best.rxStriding, best.cpu, oldCPU-best.cpu)
-		config.Striding = best.rxStriding
+	return Dimension{"striding", formatValues(listStriding, "%t"), slices.Index(listStriding, config.Striding), fmt.Sprintf("%t", config.Striding), 10, func(config Config, index int) (Config, error) {
+		config.Striding = listStriding[index]
 		setConfig(ethHandle, config)
-		bestPPS = best.pps
-		bestCPU = best.cpu
-	} else {
-		// Nessuna processa tutto → confronto throughput
-		p.Printf("Not all processed, looking for higher throughput\n")
-		if float64(newPPS) > float64(oldPPS)*PPS_THRESHOLD && float64(newPPS) > float64(extDrop)*PPS_THRESHOLD {
-			p.Printf("New Rx Striding %t is better by (PPS=%d)\n", newRxStriding, newPPS-oldPPS)
-			config.Striding = newRxStriding
-			bestPPS = newPPS
-			bestCPU = newCPU
-		} else {
-			p.Printf("Previous Rx Striding %t was better by (PPS=%d), reverting\n", oldRxStriding, oldPPS-newPPS)
-			config.Striding = oldRxStriding
-			bestPPS = oldPPS
-			bestCPU = oldCPU
-		}
-		setConfig(ethHandle, config)
-	}
-
-	return config, bestPPS, bestCPU
+		return config, nil
+	}}
 }
 
-func changeWRMSR(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, uint64, float64) {
-
-	oldPPS := getAction(ethHandle, config.Iface, interval, config.Action)
-	oldCPU := getAverageCPUPercentage(config.Weight)
-	oldNotProcessed := getNotProcessed(ethHandle, config.Iface, interval, config.Action)
-
-	var bestPPS uint64
-	var bestCPU float64
-	var newMSRval uint64
-	oldMSRval := config.MSRValue
-
-	if oldMSRval == 0x6000 {
-		newMSRval = 0x7fff
-	} else {
-		newMSRval = 0x6000
-	}
-
-	setMSR(newMSRval)
-
-	newPPS := getAction(ethHandle, config.Iface, interval, config.Action)
-	newCPU := getAverageCPUPercentage(config.Weight)
-	newNotProcessed := getNotProcessed(ethHandle, config.Iface, interval, config.Action)
-	p := message.NewPrinter(language.English)
-
-	type candidate struct {
-		msrValue uint64
-		pps      uint64
-		cpu      float64
-		name     string
-	}
-
-	var candidates []candidate
-
-	// Considera solo le configurazioni che processano tutto
-	if oldNotProcessed < DROPPED_THRESHOLD {
-		candidates = append(candidates, candidate{oldMSRval, oldPPS, oldCPU, "Old"})
-	}
-	if newNotProcessed < DROPPED_THRESHOLD {
-		candidates = append(candidates, candidate{newMSRval, newPPS, newCPU, "New"})
-	}
-
-	if len(candidates) > 0 {
-		// Tra quelle valide, scegli la meno pesante in termini di CPU
-		best := candidates[0]
-		for _, c := range candidates[1:] {
-			if c.cpu < best.cpu {
-				best = c
-			}
-		}
-		p.Printf("%s MSR %x is best (CPU=%f) by (CPU=%f)\n", best.name, best.msrValue, best.cpu, oldCPU-best.cpu)
-		config.MSRValue = best.msrValue
-		setMSR(best.msrValue)
-		bestPPS = best.pps
-		bestCPU = best.cpu
-	} else {
-		// Nessuna configurazione è completa → confronta il throughput
-		p.Printf("Not all processed, looking for higher throughput\n")
-		if float64(newPPS) > float64(oldPPS)*PPS_THRESHOLD && float64(newPPS) > float64(extDrop)*PPS_THRESHOLD {
-			p.Printf("New MSR %x is better by (PPS=%d)\n", newMSRval, newPPS-oldPPS)
-			config.MSRValue = newMSRval
-			setMSR(newMSRval)
-			bestPPS = newPPS
-			bestCPU = newCPU
-		} else {
-			p.Printf("Previous MSR %x was better by (PPS=%d), reverting\n", oldMSRval, oldPPS-newPPS)
-			config.MSRValue = oldMSRval
-			setMSR(oldMSRval)
-			bestPPS = oldPPS
-			bestCPU = oldCPU
-		}
+func changeRxStriding(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, Sample) {
+	config, sample, err := searchNeighbours(ethHandle, config, interval, extDrop, stridingDimension(ethHandle, config))
+	if err != nil {
+		panic(err.Error())
 	}
-
-	return config, bestPPS, bestCPU
-
+	return config, sample
 }
 
-func createSlice(ones uint32, start uint32) [MAX_CORES]uint32 {
-	var slice [MAX_CORES]uint32
+func createSlice(ones uint32, start uint32) []uint32 {
+	slice := make([]uint32, ones)
 	for i := start; i < ones; i++ {
 		slice[i] = 1
 	}
 	return slice
 }
 
-func changeCPUCount(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, uint64) {
-
-	oldWeight := config.Weight
-	oldCore := config.Cores
-	var maxDrop uint64
-
-	percentage := getAverageCPUPercentage(config.Weight)
-	if percentage > 80 && config.Cores < MAX_CORES {
-
-		config.Weight = createSlice(config.Cores+1, 0)
-		config.Cores++
-
-	} else if percentage < 60 && config.Cores > 1 {
-
-		percentages, err := cpu.Percent(time.Second, true)
-		percentages = percentages[:config.Cores-1]
-
-		if err != nil {
-			panic(err.Error())
-		}
-		maxPercent := slices.Max(percentages)
-		maxIndex := slices.Index(percentages, maxPercent)
-		minPercent := slices.Min(percentages)
-		minIndex := slices.Index(percentages, minPercent)
-		//traffic skewed
-		if maxPercent > 80 && minPercent < 60 {
-			fmt.Printf("CPU %d is max\n", maxIndex)
-			fmt.Printf("CPU %d is min\n", minIndex)
-			fmt.Printf("percentages %v\n", percentages)
-			equalizeIndir(ethHandle, config, uint32(minIndex), uint32(maxIndex))
-			//test
-			return config, extDrop
-		}
-
-		config.Weight = createSlice(config.Cores-1, 0)
-		config.Cores--
-
-	}
-
-	setIndir(ethHandle, config)
-
-	new := getAction(ethHandle, config.Iface, interval, config.Action)
-
-	if float64(new) > float64(extDrop)*PPS_THRESHOLD {
-		fmt.Printf("New CPU %d more than prevoius\n", config.Cores)
-		maxDrop = new
-	} else {
-		fmt.Printf("Prevous CPU %d less than prevoius reverting\n", oldCore)
-		config.Cores = oldCore
-		config.Weight = oldWeight
-		setIndir(ethHandle, config)
-		maxDrop = extDrop
-	}
-
-	// maxDrop = new
-
-	return config, maxDrop
-}
-
 func main() {
+	flag.Parse()
 
 	writer := createCSV()
+	disruptionLog = createDisruptionCSV()
 
 	ethHandle, err := ethtool.NewEthtool()
 	if err != nil {
 		panic(err.Error())
 	}
 	defer ethHandle.Close()
+	// tutto quello che viene modificato si registra con atShutdown
+	defer shutdown()
+	handleSignals()
 
 	config := Config{
 		Iface:       "enp52s0f1np1",
@@ -778,33 +380,186 @@ func main() {
 		RXQueue:     1024,
 		CQECompress: true,
 		Striding:    true,
-		Weight:      [32]uint32{1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
-		Cores:       10,
-		MSR:         0xc8b,
-		MSRValue:    0x6000,
+		Cores:       0, // 0 uses every channel the NIC and the core set allow
+		Scaling: ScalingConfig{
+			Mode:          SCALING_AUTO,
+			HighWatermark: 80,
+			LowWatermark:  60,
+			SkewThreshold: 30,
+			MinCores:      1,
+			MaxCores:      0, // all the channels the NIC supports
+			Cooldown:      30 * time.Second,
+			UseChannels:   true,
+			HashAfter:     3,
+			HashFlowTypes: []string{"udp4", "tcp4"},
+		},
+		CPUList: "",
+		SMT:     false,
+		Steering: SteeringConfig{
+			Enabled:   true,
+			HeavyPPS:  1000000,
+			ColdPPS:   100000,
+			ColdAfter: time.Minute,
+			MaxRules:  16,
+			FirstRule: 0,
+			Period:    INTERVAL * time.Second,
+		},
+		Objective: ObjectiveConfig{
+			Name:      OBJECTIVE_CPU,
+			Normalize: true,
+		},
+		Constraints: Constraints{
+			MaxLossRate: 0,
+			MaxCoreCPU:  0,
+		},
+		Significance: SignificanceConfig{
+			SubWindows: 5,
+			Alpha:      0.05,
+		},
+		Window: WindowConfig{
+			Adaptive:   true,
+			MinTime:    2 * time.Second,
+			MaxTime:    3 * INTERVAL * time.Second,
+			Confidence: 0.95,
+			RelWidth:   0.02,
+		},
+		Interleave: InterleaveConfig{
+			Enabled:      true,
+			Knobs:        []string{DDIO_KNOB}, // cheap to flip, setConfig recreates the queues
+			Alternations: 4,
+			Window:       time.Second,
+			Settle:       200 * time.Millisecond,
+		},
+		Settle: SettleConfig{
+			// ring e striding ricreano le code, le flag CQE no
+			Delays: map[string]time.Duration{
+				"rxqueue":  2 * time.Second,
+				"budget":   2 * time.Second,
+				"striding": 2 * time.Second,
+				"cores":    DISRUPTION_WINDOW * time.Second, // il core scaler cambia i canali
+			},
+			Auto:      true,
+			Step:      500 * time.Millisecond,
+			Tolerance: 0.05,
+			MaxWait:   5 * time.Second,
+		},
+		Search: SearchConfig{
+			Strategy:       SEARCH_NEIGHBOUR,
+			MaxEvaluations: 30,
+			Seed:           0,
+			Temperature:    0.05,
+			Cooling:        0.9,
+			InitialPoints:  5,
+			LengthScale:    0.25,
+			Noise:          0.05,
+		},
+		Bandit: BanditConfig{
+			Policy:      BANDIT_UCB1,
+			Exploration: 1,
+			Penalty:     1,
+		},
+		RSSContext: 0,
+		Resctrl: ResctrlConfig{
+			Enabled: true,
+			Root:    "/sys/fs/resctrl",
+			Group:   "tune-xdp",
+		},
+		CPUFreq: CPUFreqConfig{
+			Governor: false,
+			MinFreq:  true,
+			MaxFreq:  true,
+			EPP:      true,
+			Uncore:   true,
+			Step:     100000,
+		},
+		Idle: IdleConfig{
+			Enabled: true,
+			Mode:    IDLE_CPUIDLE,
+		},
+		// Contexts: []RSSContextConfig{{Name: "app", Queues: []uint32{14, 15}, Rules: []NtupleRule{{Location: 100, Proto: 17, DstPort: [2]byte{0x1f, 0x90}}}}},
+	}
+	config = loadConfig(*configPath, config)
+	config.CoreSet = selectCores(config.Iface, config.CPUList, config.SMT)
+	maxCores := min(getChannels(ethHandle, config.Iface).MaxCombined, uint32(len(config.CoreSet)))
+	// le code degli altri workload non vanno mai toccate
+	if first, ok := reservedQueues(config); ok {
+		maxCores = min(maxCores, first)
+		if config.Scaling.UseChannels {
+			fmt.Printf("Queues from %d belong to other RSS contexts, scaling indirection weights instead of channels\n", first)
+			config.Scaling.UseChannels = false
+		}
+	}
+	if config.Cores == 0 || config.Cores > maxCores {
+		fmt.Printf("Using %d RX cores\n", maxCores)
+		config.Cores = maxCores
 	}
+	config.Weight = createSlice(config.Cores, 0)
 	setConfig(ethHandle, config)
-	setIndir(ethHandle, config)
-	setMSR(config.MSRValue)
+	if len(config.Contexts) == 0 {
+		setChannels(ethHandle, config.Iface, config.Cores)
+	}
+	setIndir(config)
+	contexts := createContexts(config)
+	atShutdown(func() { deleteContexts(config.Iface, contexts) })
+	applyIRQAffinity(ethHandle, config)
+	if knob, err := newDDIOKnob(detectCPU()); err != nil {
+		fmt.Printf("%s, DDIO disabled\n", err)
+	} else {
+		config.MSRKnobs = append(config.MSRKnobs, knob)
+	}
+	config = initMSRKnobs(config)
+	atShutdown(restoreMSRs)
+	config.SysfsKnobs = append(config.SysfsKnobs, cpufreqKnobs(config)...)
+	config = initSysfsKnobs(config)
+	atShutdown(restoreSysfs)
+	if config.Idle.Enabled {
+		idleCtl, config.IdleLimit, err = newIdleControl(config.Idle, config)
+		if err != nil {
+			fmt.Printf("%s, idle control disabled\n", err)
+		} else {
+			atShutdown(idleCtl.close)
+		}
+	}
+	energyMeter, err = newRAPL(POWERCAP_ROOT)
+	if err != nil {
+		fmt.Printf("%s, energy measurement disabled\n", err)
+	}
+	objective = newObjective(config.Objective)
+	if config.Resctrl.Enabled {
+		cacheAlloc, err = newResctrl(config.Resctrl, config)
+		if err != nil {
+			fmt.Printf("%s, cache allocation disabled\n", err)
+		} else {
+			atShutdown(cacheAlloc.remove)
+			config.L3Mask, err = cacheAlloc.getMask()
+			if err != nil {
+				panic(err.Error())
+			}
+		}
+	}
 
-	// prova := ethtool.SetIndir{}
-	// newIndir := [256]uint32{0}
+	// newIndir := make([]uint32, len(getIndir(config.Iface, config.RSSContext)))
 	// newIndir[0] = 1
-	// prova.RingIndex = newIndir
-	// overrideIndir(ethHandle, config.Iface, prova)
+	// overrideIndir(config.Iface, config.RSSContext, newIndir)
 
-	// getAverageCPUPercentage(config.Weight)
-	// return
+	var sample Sample
+	scaler := newCoreScaler(ethHandle, config)
 
-	var pps uint64
-	var cpuUsage float64
+	xdpLink, objs := attachXDP(config.Iface)
+	atShutdown(func() {
+		xdpLink.Close()
+		objs.Close()
+	})
 
-	xdpLink := attachXDP(config.Iface)
-	defer xdpLink.Close()
+	if config.Steering.Enabled {
+		steering = newFlowSteering(config, objs.Heavy)
+		atShutdown(steering.close)
+		steering.start()
+	}
 
 	//baseline
-	pps = getAction(ethHandle, config.Iface, INTERVAL, config.Action)
-	writeCSV(writer, config, pps, getAverageCPUPercentage(config.Weight))
+	sample = measure(ethHandle, config, INTERVAL)
+	writeCSV(writer, config, sample)
 
 	// config.RXQueue = 128
 	// config.Budget = 2
@@ -812,27 +567,39 @@ func main() {
 	// config.Striding = false
 	// setConfig(ethHandle, config)
 
-	for {
-		pps = 0
-
-		config, pps, cpuUsage = changeRxQueue(ethHandle, config, INTERVAL, pps)
-		writeCSV(writer, config, pps, cpuUsage)
-
-		config, pps, cpuUsage = changeRxBudget(ethHandle, config, INTERVAL, pps)
-		writeCSV(writer, config, pps, cpuUsage)
-
-		config, pps, cpuUsage = changeCqeCompress(ethHandle, config, INTERVAL, pps)
-		writeCSV(writer, config, pps, cpuUsage)
-
-		config, pps, cpuUsage = changeRxStriding(ethHandle, config, INTERVAL, pps)
-		writeCSV(writer, config, pps, cpuUsage)
+	// bracci e strategie restano gli stessi per tutta l'esecuzione, le statistiche si accumulano
+	var arms *bandit
+	var joint *jointSearch
+	switch config.Search.Strategy {
+	case SEARCH_NEIGHBOUR:
+	case SEARCH_BANDIT:
+		arms = newBandit(config, searchSpace(ethHandle, config, scaler))
+	default:
+		joint = newJointSearch(config, searchSpace(ethHandle, config, scaler))
+	}
+
+	for !stopping() {
+		if arms != nil {
+			config, sample = arms.pull(ethHandle, config, INTERVAL)
+			writeCSV(writer, config, sample)
+			continue
+		}
 
-		// config, pps, cpuUsage = changeCPUCount(ethHandle, config, INTERVAL, pps)
-		// writeCSV(writer, config, pps, cpuUsage)
+		if joint != nil {
+			config, sample = joint.round(ethHandle, config, INTERVAL, writer)
+			continue
+		}
 
-		config, pps, cpuUsage = changeWRMSR(ethHandle, config, INTERVAL, pps)
-		writeCSV(writer, config, pps, cpuUsage)
+		// si parte dai knob che possono risolvere la causa principale delle perdite
+		steps := routeLoss(tuningSteps(ethHandle, config, scaler), sample)
+		sample = Sample{}
 
+		for _, step := range steps {
+			if stopping() {
+				break
+			}
+			config, sample = step.change(config, sample.PPS)
+			writeCSV(writer, config, sample)
+		}
 	}
-
 }
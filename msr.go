@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+	"github.com/u-root/u-root/pkg/msr"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+const (
+	MSR_SCOPE_ALL    = "all"    // every CPU
+	MSR_SCOPE_SOCKET = "socket" // every CPU of one socket
+	MSR_SCOPE_NIC    = "nic"    // every core of the core set, also the ones the scaler adds later
+)
+
+/*
+MSRKnob is a model specific register the tuner may sweep. With Width zero the whole
+register is written with one of Values, otherwise only bits [Shift, Shift+Width) are
+changed, taking Values or every value between Min and Max.
+*/
+type MSRKnob struct {
+	Name     string
+	Register uint32
+	Values   []uint64
+	Shift    uint
+	Width    uint
+	Min      uint64
+	Max      uint64
+	Scope    string
+	Socket   int        // socket written with MSR_SCOPE_SOCKET, -1 is the socket of the NIC, 0 if unknown
+	Models   []CPUModel // CPUs the register is valid on, empty uses msrModels
+}
+
+func (k MSRKnob) mask() uint64 {
+	if k.Width == 0 || k.Width >= 64 {
+		return ^uint64(0)
+	}
+	return ((uint64(1) << k.Width) - 1) << k.Shift
+}
+
+// values returns the values the knob can take, in sweep order
+func (k MSRKnob) values() []uint64 {
+	if len(k.Values) > 0 {
+		return k.Values
+	}
+	var values []uint64
+	for value := k.Min; value <= k.Max; value++ {
+		values = append(values, value)
+	}
+	return values
+}
+
+func getCPUSocket(cpu int) int {
+	data, err := os.ReadFile(fmt.Sprintf("/sys/devices/system/cpu/cpu%d/topology/physical_package_id", cpu))
+	if err != nil {
+		panic(err.Error())
+	}
+	socket, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		panic(err.Error())
+	}
+	return socket
+}
+
+// getNICSocket returns the socket hosting the NIC, -1 if the platform does not report it
+func getNICSocket(iface string) int {
+	node := getNICNumaNode(iface)
+	if node < 0 {
+		return -1
+	}
+	cpus := readCPUList(fmt.Sprintf("/sys/devices/system/node/node%d/cpulist", node))
+	if len(cpus) == 0 {
+		return -1
+	}
+	return getCPUSocket(cpus[0])
+}
+
+// cpus returns the CPUs the knob is written on
+func (k MSRKnob) cpus(config Config) (msr.CPUs, error) {
+	var cpus msr.CPUs
+	switch k.Scope {
+	case MSR_SCOPE_NIC:
+		// anche i core non ancora attivi, altrimenti quelli aggiunti dopo hanno il valore vecchio
+		for _, cpu := range config.CoreSet {
+			cpus = append(cpus, uint64(cpu))
+		}
+	case MSR_SCOPE_SOCKET:
+		socket := k.Socket
+		if socket < 0 {
+			socket = getNICSocket(config.Iface)
+		}
+		// socket singolo o VM: numa_node vale -1
+		if socket < 0 {
+			socket = 0
+		}
+		for _, cpu := range readCPUList("/sys/devices/system/cpu/online") {
+			if getCPUSocket(cpu) == socket {
+				cpus = append(cpus, uint64(cpu))
+			}
+		}
+	default:
+		all, err := msr.AllCPUs()
+		if err != nil {
+			return nil, err
+		}
+		cpus = all
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("no CPU in scope %s of MSR %s", k.Scope, k.Name)
+	}
+	return cpus, nil
+}
+
+// readMSRKnob returns the current value of the knob, the field only for bit-field knobs
+func readMSRKnob(k MSRKnob, config Config) (uint64, error) {
+	cpus, err := k.cpus(config)
+	if err != nil {
+		return 0, err
+	}
+	regs, errs := msr.MSR(k.Register).Read(cpus[:1])
+	if errs != nil {
+		return 0, fmt.Errorf("reading MSR %s (%#x): %w", k.Name, k.Register, errors.Join(errs...))
+	}
+	return (regs[0] & k.mask()) >> k.Shift, nil
+}
+
+func setMSRKnob(k MSRKnob, config Config, value uint64) error {
+	r := msr.MSR(k.Register)
+	cpus, err := k.cpus(config)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if k.Width == 0 {
+		errs = r.Write(cpus, value)
+	} else {
+		errs = r.TestAndSet(cpus, k.mask(), (value<<k.Shift)&k.mask())
+	}
+	if errs != nil {
+		return fmt.Errorf("writing %#x to MSR %s (%#x) on CPUs %v: %w", value, k.Name, k.Register, cpus, errors.Join(errs...))
+	}
+	// fmt.Printf("Set MSR %s to %x\n", k.Name, value)
+	return nil
+}
+
+// msrOriginals holds the registers as they were before tuning, by register and CPU
+var msrOriginals = make(map[uint32]map[uint64]uint64)
+
+// saveMSR records the whole register on every CPU the knob may write, a register shared by two knobs only once
+func saveMSR(k MSRKnob, config Config) error {
+	cpus, err := k.cpus(config)
+	if err != nil {
+		return err
+	}
+	regs, errs := msr.MSR(k.Register).Read(cpus)
+	if errs != nil {
+		return fmt.Errorf("reading MSR %s (%#x) on CPUs %v: %w", k.Name, k.Register, cpus, errors.Join(errs...))
+	}
+	if msrOriginals[k.Register] == nil {
+		msrOriginals[k.Register] = make(map[uint64]uint64)
+	}
+	for i, cpu := range cpus {
+		if _, ok := msrOriginals[k.Register][cpu]; !ok {
+			msrOriginals[k.Register][cpu] = regs[i]
+		}
+	}
+	return nil
+}
+
+func restoreMSRs() {
+	for register, cpus := range msrOriginals {
+		for cpu, value := range cpus {
+			if errs := msr.MSR(register).Write(msr.CPUs{cpu}, value); errs != nil {
+				fmt.Printf("Restoring MSR %#x on CPU %d: %s\n", register, cpu, errors.Join(errs...))
+			}
+		}
+	}
+}
+
+// disableMSRKnob removes a knob from the tuning, config.MSRKnobs is shared with older copies
+func disableMSRKnob(config Config, name string) Config {
+	config.MSRKnobs = slices.DeleteFunc(slices.Clone(config.MSRKnobs), func(k MSRKnob) bool {
+		return k.Name == name
+	})
+	config.MSRValues = maps.Clone(config.MSRValues)
+	delete(config.MSRValues, name)
+	return config
+}
+
+/*
+initMSRKnobs drops the knobs not known to be valid on this CPU, then reads the current
+value of every knob and uses it as the starting point, saving the registers for restoreMSRs. A current value that is not among
+the allowed ones is added, so it can be kept. Knobs that cannot be read are disabled.
+*/
+func initMSRKnobs(config Config) Config {
+	cpu := detectCPU()
+	fmt.Printf("CPU is %s\n", cpu)
+
+	config.MSRValues = make(map[string]uint64)
+	var knobs []MSRKnob
+	for _, knob := range config.MSRKnobs {
+		if !msrSupported(knob, cpu) {
+			fmt.Printf("MSR %s (%#x) is not known to be valid on %s, disabled\n", knob.Name, knob.Register, cpu)
+			continue
+		}
+		if _, err := knob.cpus(config); err != nil {
+			fmt.Printf("%s, MSR %s disabled\n", err, knob.Name)
+			continue
+		}
+		value, err := readMSRKnob(knob, config)
+		if err != nil {
+			fmt.Printf("%s, MSR %s disabled (is the msr module loaded?)\n", err, knob.Name)
+			continue
+		}
+		if err := saveMSR(knob, config); err != nil {
+			fmt.Printf("%s, MSR %s disabled\n", err, knob.Name)
+			continue
+		}
+		if len(knob.Values) > 0 && !slices.Contains(knob.Values, value) {
+			fmt.Printf("MSR %s current value %#x is not among %#x, adding it\n", knob.Name, value, knob.Values)
+			knob.Values = append(slices.Clone(knob.Values), value)
+			slices.Sort(knob.Values)
+		}
+		fmt.Printf("MSR %s (%#x) is %#x\n", knob.Name, knob.Register, value)
+		config.MSRValues[knob.Name] = value
+		knobs = append(knobs, knob)
+	}
+	config.MSRKnobs = knobs
+	return config
+}
+
+func msrString(config Config) string {
+	var values []string
+	for _, knob := range config.MSRKnobs {
+		values = append(values, fmt.Sprintf("%s=%x", knob.Name, config.MSRValues[knob.Name]))
+	}
+	return strings.Join(values, ";")
+}
+
+// msrDimension is an MSR knob as searched
+func msrDimension(config Config, knob MSRKnob) Dimension {
+	values := knob.values()
+
+	return Dimension{knob.Name, formatValues(values, "%x"), slices.Index(values, config.MSRValues[knob.Name]), fmt.Sprintf("%x", config.MSRValues[knob.Name]), 16, func(config Config, index int) (Config, error) {
+		if err := setMSRKnob(knob, config, values[index]); err != nil {
+			return config, err
+		}
+		config.MSRValues = maps.Clone(config.MSRValues)
+		config.MSRValues[knob.Name] = values[index]
+		return config, nil
+	}}
+}
+
+/*
+test and update an MSR knob, trying the values next to the current one
+*/
+func changeMSR(ethHandle *ethtool.Ethtool, config Config, knob MSRKnob, interval int, extDrop uint64) (Config, Sample) {
+	original := config.MSRValues[knob.Name]
+
+	var failed error
+	dimension := msrDimension(config, knob)
+	apply := dimension.Apply
+	dimension.Apply = func(config Config, index int) (Config, error) {
+		config, err := apply(config, index)
+		if err != nil {
+			failed = err
+		}
+		return config, err
+	}
+	config, sample, err := searchNeighbours(ethHandle, config, interval, extDrop, dimension)
+
+	// una scrittura fallita disabilita il knob, dopo aver provato a rimettere il valore di prima
+	if err != nil || failed != nil {
+		p := message.NewPrinter(language.English)
+		p.Printf("%s, MSR %s disabled\n", errors.Join(err, failed), knob.Name)
+		if err := setMSRKnob(knob, config, original); err != nil {
+			p.Printf("Restoring MSR %s: %s\n", knob.Name, err)
+		}
+		return disableMSRKnob(config, knob.Name), sample
+	}
+	return config, sample
+}
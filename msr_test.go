@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMSRKnobMask(t *testing.T) {
+	tests := []struct {
+		shift, width uint
+		want         uint64
+	}{
+		// senza larghezza si scrive tutto il registro
+		{0, 0, ^uint64(0)},
+		{0, 64, ^uint64(0)},
+		{0, 8, 0xff},
+		{8, 4, 0xf00},
+		{63, 1, 1 << 63},
+	}
+	for _, test := range tests {
+		k := MSRKnob{Shift: test.shift, Width: test.width}
+		if got := k.mask(); got != test.want {
+			t.Errorf("mask with shift %d and width %d = %#x, want %#x", test.shift, test.width, got, test.want)
+		}
+	}
+}
+
+func TestMSRKnobValues(t *testing.T) {
+	tests := []struct {
+		name string
+		knob MSRKnob
+		want []uint64
+	}{
+		// i valori elencati vincono sull'intervallo, nel loro ordine
+		{"listed", MSRKnob{Values: []uint64{0x600, 0x7ff, 0x400}, Min: 1, Max: 3}, []uint64{0x600, 0x7ff, 0x400}},
+		{"range", MSRKnob{Min: 2, Max: 5}, []uint64{2, 3, 4, 5}},
+		{"single", MSRKnob{Min: 7, Max: 7}, []uint64{7}},
+	}
+	for _, test := range tests {
+		if got := test.knob.values(); !slices.Equal(got, test.want) {
+			t.Errorf("%s: values = %#x, want %#x", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMSRString(t *testing.T) {
+	config := Config{
+		MSRKnobs:  []MSRKnob{{Name: "ddio"}, {Name: "prefetch"}},
+		MSRValues: map[string]uint64{"ddio": 0x600, "prefetch": 0xf},
+	}
+	if got, want := msrString(config), "ddio=600;prefetch=f"; got != want {
+		t.Errorf("msrString = %q, want %q", got, want)
+	}
+}
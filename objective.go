@@ -0,0 +1,496 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+const (
+	OBJECTIVE_CPU            = "cpu"            // min CPU subject to zero loss
+	OBJECTIVE_THROUGHPUT     = "throughput"     // max packets per second
+	OBJECTIVE_ENERGY         = "energy"         // min joules per million received packets
+	OBJECTIVE_CPU_PER_PACKET = "cpu_per_packet" // min CPU of all the RX cores per Mpps
+	OBJECTIVE_WEIGHTED       = "weighted"       // weighted sum of the metrics in Weights
+)
+
+type ObjectiveConfig struct {
+	Name      string
+	Weights   map[string]float64 // metric weights for OBJECTIVE_WEIGHTED, negative ones are maximised
+	Normalize bool               // compare built-in objectives per received packet, not raw
+}
+
+type Constraints struct {
+	MaxLossRate float64               // not processed over received, 0 keeps the DROPPED_THRESHOLD check
+	MaxCoreCPU  float64               // busiest RX core, 0 disables
+	Ranges      map[string][2]float64 // allowed [min, max] of a knob, by knob name
+}
+
+/*
+Sample is what one measurement window of a configuration observed. Everything comes
+from the same window, so rates can be normalized by the input rate the configuration
+actually saw instead of compared raw while the offered load drifts.
+*/
+type Sample struct {
+	PPS          uint64 // action rate
+	InputPPS     uint64 // rx_packets_phy rate
+	NotProcessed int
+	CPU          float64   // average of the active RX cores
+	CoreCPU      []float64 // every active RX core, in queue order
+	CoreQueues   []uint32  // queue of every CoreCPU entry, cores without a reading are skipped
+	MaxCoreCPU   float64
+	Watts        float64           // package and DRAM power, 0 without RAPL
+	Energy       float64           // joules per million received packets, 0 without RAPL
+	LLCOccupancy uint64            // LLC bytes held by the RX cores at the end of the window, 0 without resctrl monitoring
+	MemBandwidth float64           // MB/s the RX cores moved to and from memory, 0 without resctrl monitoring
+	Loss         map[string]uint64 // rate of every loss cause, by LOSS_*
+	Invalid      string            // why the counters of the window cannot be trusted, "" when valid
+}
+
+func (s Sample) lossRate() float64 {
+	if s.InputPPS == 0 {
+		return 0
+	}
+	return float64(max(s.NotProcessed, 0)) / float64(s.InputPPS)
+}
+
+// cpuPerMpps returns the CPU of all the active RX cores per million received packets per second
+func (s Sample) cpuPerMpps() float64 {
+	if s.InputPPS == 0 {
+		return math.Inf(1)
+	}
+	return s.CPU * float64(len(s.CoreCPU)) / (float64(s.InputPPS) / 1e6)
+}
+
+// processed returns the fraction of the received packets that reached the action
+func (s Sample) processed() float64 {
+	if s.InputPPS == 0 {
+		return 0
+	}
+	return float64(s.PPS) / float64(s.InputPPS)
+}
+
+// metric returns a named quantity of the sample, the names weighted objectives use
+func (s Sample) metric(name string) float64 {
+	switch name {
+	case "cpu":
+		return s.CPU
+	case "max_core_cpu":
+		return s.MaxCoreCPU
+	case "pps":
+		return float64(s.PPS)
+	case "energy":
+		return s.Energy
+	case "input_pps":
+		return float64(s.InputPPS)
+	case "processed":
+		return s.processed()
+	case "watts":
+		return s.Watts
+	case "llc_occupancy_kb":
+		return float64(s.LLCOccupancy) / 1024
+	case "mbm_mbps":
+		return s.MemBandwidth
+	case "cpu_per_mpps":
+		return s.cpuPerMpps()
+	case "loss_rate":
+		return s.lossRate()
+	}
+	panic(fmt.Sprintf("unknown metric %s", name))
+}
+
+// Objective ranks the configurations that satisfy the constraints
+type Objective interface {
+	Name() string
+	Cost(s Sample) float64 // lower is better
+}
+
+// weightedObjective is a weighted sum of metrics, the built-in objectives are sums of one metric
+type weightedObjective struct {
+	name    string
+	weights map[string]float64
+}
+
+func (o weightedObjective) Name() string {
+	return o.name
+}
+
+func (o weightedObjective) Cost(s Sample) float64 {
+	var cost float64
+	for metric, weight := range o.weights {
+		cost += weight * s.metric(metric)
+	}
+	return cost
+}
+
+// objective is the one every change function optimises
+var objective Objective = weightedObjective{OBJECTIVE_CPU, map[string]float64{"cpu": 1}}
+
+func newObjective(conf ObjectiveConfig) Objective {
+	var weights map[string]float64
+	switch conf.Name {
+	case "", OBJECTIVE_CPU:
+		weights = map[string]float64{"cpu": 1}
+		if conf.Normalize {
+			weights = map[string]float64{"cpu_per_mpps": 1}
+		}
+	case OBJECTIVE_THROUGHPUT:
+		weights = map[string]float64{"pps": -1}
+		if conf.Normalize {
+			weights = map[string]float64{"processed": -1}
+		}
+	case OBJECTIVE_ENERGY:
+		weights = map[string]float64{"energy": 1}
+	case OBJECTIVE_CPU_PER_PACKET:
+		weights = map[string]float64{"cpu_per_mpps": 1}
+	case OBJECTIVE_WEIGHTED:
+		weights = conf.Weights
+	default:
+		panic(fmt.Sprintf("unknown objective %s", conf.Name))
+	}
+	for metric := range weights {
+		// controlla subito i nomi, non a meta' tuning
+		Sample{}.metric(metric)
+		if metric == "energy" && energyMeter == nil {
+			fmt.Printf("Objective %s uses energy but RAPL is not available, energy is always 0\n", conf.Name)
+		}
+	}
+	return weightedObjective{conf.Name, weights}
+}
+
+// feasible reports whether a sample satisfies the loss and CPU constraints
+func feasible(config Config, s Sample) bool {
+	if s.Invalid != "" {
+		return false
+	}
+	if config.Constraints.MaxLossRate > 0 {
+		if s.lossRate() > config.Constraints.MaxLossRate {
+			return false
+		}
+	} else if s.NotProcessed >= DROPPED_THRESHOLD {
+		return false
+	}
+	if config.Constraints.MaxCoreCPU > 0 && s.MaxCoreCPU > config.Constraints.MaxCoreCPU {
+		return false
+	}
+	return true
+}
+
+// allowed reports whether a dimension may take its value at index, values that are not numbers are always allowed
+func allowed(config Config, d Dimension, index int) bool {
+	limits, ok := config.Constraints.Ranges[d.Name]
+	if !ok {
+		return true
+	}
+	value := d.Values[index]
+	var number float64
+	if d.Base == 16 {
+		// registri e maschere sono in esadecimale, "600" non e' 600
+		n, err := strconv.ParseUint(value, 16, 64)
+		if err != nil {
+			return true
+		}
+		number = float64(n)
+	} else if n, err := strconv.ParseFloat(value, 64); err == nil {
+		number = n
+	} else {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return true
+		}
+		if b {
+			number = 1
+		}
+	}
+	return number >= limits[0] && number <= limits[1]
+}
+
+// measure runs one measurement window of interval seconds, or an adaptive one when enabled
+func measure(ethHandle *ethtool.Ethtool, config Config, interval int) Sample {
+	if config.Window.Adaptive {
+		sample, _ := measureSamples(ethHandle, config, interval, config.Significance.SubWindows)
+		return sample
+	}
+	return measureFor(ethHandle, config, time.Duration(interval)*time.Second)
+}
+
+/*
+measureWindow runs one measurement window of the current configuration, reading the NIC
+counters, the CPU usage and the energy at its start and at its end.
+*/
+func measureWindow(ethHandle *ethtool.Ethtool, config Config, duration time.Duration) Sample {
+	seconds := duration.Seconds()
+
+	stats, err := ethHandle.Stats(config.Iface)
+	if err != nil {
+		panic(err.Error())
+	}
+	pre := stats
+	prePhy := stats["rx_packets_phy"]
+	preAction := stats[config.Action]
+	var energyPre []uint64
+	if energyMeter != nil {
+		energyPre = energyMeter.read()
+	}
+	var mbmPre uint64
+	mbmRead := false
+	if cacheAlloc != nil {
+		pre, err := cacheAlloc.mbmTotal()
+		mbmPre, mbmRead = pre, err == nil
+	}
+	// con intervallo 0 gopsutil misura dalla chiamata precedente
+	if _, err := cpu.Percent(0, true); err != nil {
+		panic(err.Error())
+	}
+
+	time.Sleep(duration)
+
+	percentages, err := cpu.Percent(0, true)
+	if err != nil {
+		panic(err.Error())
+	}
+	stats, err = ethHandle.Stats(config.Iface)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	var s Sample
+	// contatori azzerati durante la finestra, i delta non valgono niente
+	if s.Invalid = checkCounters(pre, stats, config.Action); s.Invalid != "" {
+		return s
+	}
+	s.PPS = uint64(float64(stats[config.Action]-preAction) / seconds)
+	s.InputPPS = uint64(float64(stats["rx_packets_phy"]-prePhy) / seconds)
+	s.NotProcessed = int(s.InputPPS) - int(s.PPS)
+	s.Loss = lossBreakdown(pre, stats, seconds, s.NotProcessed)
+	for _, queue := range activeQueues(config) {
+		if core := config.CoreSet[queue]; core < len(percentages) {
+			s.CoreCPU = append(s.CoreCPU, percentages[core])
+			s.CoreQueues = append(s.CoreQueues, queue)
+		}
+	}
+	if len(s.CoreCPU) > 0 {
+		var sum float64
+		for _, percent := range s.CoreCPU {
+			sum += percent
+		}
+		s.CPU = sum / float64(len(s.CoreCPU))
+		s.MaxCoreCPU = slices.Max(s.CoreCPU)
+	}
+	if energyMeter != nil {
+		joules := energyMeter.joules(energyPre, energyMeter.read())
+		s.Watts = joules / seconds
+		s.Energy = math.Inf(1)
+		if s.InputPPS > 0 {
+			s.Energy = joules / (float64(s.InputPPS) * seconds / 1e6)
+		}
+	}
+	if cacheAlloc != nil {
+		if occupancy, err := cacheAlloc.llcOccupancy(); err == nil {
+			s.LLCOccupancy = occupancy
+		}
+		if mbmPost, err := cacheAlloc.mbmTotal(); err == nil && mbmRead {
+			s.MemBandwidth = mbmRate(mbmPre, mbmPost, seconds)
+		}
+	}
+	return s
+}
+
+// formatValues turns the values of a knob into the strings searchNeighbours works on
+func formatValues[T any](values []T, format string) []string {
+	var strs []string
+	for _, value := range values {
+		strs = append(strs, fmt.Sprintf(format, value))
+	}
+	return strs
+}
+
+/*
+searchNeighbours measures the current value of a dimension and the values next to it,
+each set with its Apply, and leaves the knob on the best one. Among the values satisfying the
+constraints the objective decides, when none does the one with clearly higher
+throughput wins, otherwise the current value is kept. Each value is measured over
+sub-windows and moving away from a current value that satisfies the constraints needs
+a Welch t-test significant at Significance.Alpha, or a paired t-test when the knob is
+interleaved with the current value. Values outside the allowed range
+are not tried and values Apply fails on are skipped; the error is returned only if the
+best value cannot be set back. Every change is settled and its cost recorded through
+reconfigure.
+*/
+func searchNeighbours(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64, dimension Dimension) (Config, Sample, error) {
+	p := message.NewPrinter(language.English)
+	knob, values, current, apply := dimension.Name, dimension.Values, dimension.Current, dimension.Apply
+
+	// un valore fuori lista (es. dal file di configurazione) si porta al piu' vicino prima di confrontare
+	if current < 0 {
+		current = dimension.nearest()
+		p.Printf("%s %s is not among %v, starting from %s\n", knob, dimension.Value, values, values[current])
+		next, err := reconfigure(ethHandle, config, knob, "unknown", values[current], func() (Config, error) {
+			return apply(config, current)
+		}, func(config Config) { settleKnob(ethHandle, config, knob) })
+		if err != nil {
+			return config, measure(ethHandle, config, interval), err
+		}
+		config = next
+	}
+
+	type trial struct {
+		name    string
+		index   int
+		sample  Sample
+		samples []Sample // one per sub-window
+		paired  []Sample // Old windows interleaved with this one
+	}
+
+	subWindows := config.Significance.SubWindows
+	interleave := interleaved(config, knob)
+	trials := []trial{{name: "Old", index: current}}
+	if !interleave {
+		trials[0].sample, trials[0].samples = measureSamples(ethHandle, config, interval, subWindows)
+	}
+	applied := current
+
+	// ogni cambio conta come costo di switching, poi si aspetta che il knob si assesti
+	from := values[current]
+	setter := func(settle func(Config)) func(Config, int) (Config, error) {
+		return func(config Config, index int) (Config, error) {
+			next, err := reconfigure(ethHandle, config, knob, from, values[index], func() (Config, error) {
+				return apply(config, index)
+			}, settle)
+			from = values[index]
+			return next, err
+		}
+	}
+	set := setter(func(config Config) { settleKnob(ethHandle, config, knob) })
+	flip := setter(func(Config) { time.Sleep(config.Interleave.Settle) })
+
+	//gathers data for the neighbouring values
+	for _, neighbour := range []trial{{name: "Prev", index: current - 1}, {name: "Next", index: current + 1}} {
+		if neighbour.index < 0 || neighbour.index >= len(values) {
+			continue
+		}
+		if !allowed(config, dimension, neighbour.index) {
+			continue
+		}
+		if interleave {
+			next, paired, samples, err := measureInterleaved(ethHandle, config, flip, current, neighbour.index)
+			// dopo un errore non si sa quale valore sia rimasto
+			applied = -1
+			if err != nil {
+				p.Printf("%s, skipping %s %s\n", err, knob, values[neighbour.index])
+				continue
+			}
+			applied = neighbour.index
+			config = next
+			neighbour.sample, neighbour.samples, neighbour.paired = aggregate(samples), samples, paired
+			trials[0].samples = append(trials[0].samples, paired...)
+			trials = append(trials, neighbour)
+			continue
+		}
+		next, err := set(config, neighbour.index)
+		applied = neighbour.index
+		if err != nil {
+			p.Printf("%s, skipping %s %s\n", err, knob, values[neighbour.index])
+			continue
+		}
+		config = next
+		neighbour.sample, neighbour.samples = measureSamples(ethHandle, config, interval, subWindows)
+		trials = append(trials, neighbour)
+	}
+	if interleave {
+		if len(trials[0].samples) == 0 {
+			trials[0].sample, trials[0].samples = measureSamples(ethHandle, config, interval, subWindows)
+		} else {
+			trials[0].sample = aggregate(trials[0].samples)
+		}
+	}
+
+	old := trials[0]
+	best := old
+	found := false
+	// Considera solo le configurazioni che rispettano i vincoli
+	for _, t := range trials {
+		if feasible(config, t.sample) && (!found || objective.Cost(t.sample) < objective.Cost(best.sample)) {
+			best = t
+			found = true
+		}
+	}
+
+	// il rumore non basta per cambiare, serve un miglioramento significativo rispetto a Old
+	significant := func(metric func(Sample) float64) bool {
+		var a, b []float64
+		baseline := old.samples
+		if best.paired != nil {
+			baseline = best.paired
+		}
+		for _, s := range baseline {
+			a = append(a, metric(s))
+		}
+		for _, s := range best.samples {
+			b = append(b, metric(s))
+		}
+		pValue, effect := welchTest(a, b)
+		if best.paired != nil {
+			pValue, effect = pairedTest(a, b)
+		}
+		p.Printf("%s %s %s against %s: p-value %f, effect size %f\n", best.name, knob, values[best.index], values[current], pValue, effect)
+		if pValue >= config.Significance.Alpha {
+			p.Printf("Not significant at %f, keeping %s %s\n", config.Significance.Alpha, knob, values[current])
+			return false
+		}
+		return true
+	}
+
+	if found {
+		p.Printf("%s %s %s is best (%s=%f) by (%s=%f)\n", best.name, knob, values[best.index], objective.Name(), objective.Cost(best.sample), objective.Name(), objective.Cost(old.sample)-objective.Cost(best.sample))
+		if best.index != current && feasible(config, old.sample) && !significant(objective.Cost) {
+			best = old
+		}
+	} else {
+		// Nessuna configurazione processa tutto -> massimizza il throughput
+		p.Printf("Not all processed, looking for higher throughput\n")
+		// normalizzando, extDrop viene da un'altra finestra e non e' confrontabile
+		throughput := func(s Sample) float64 {
+			if config.Objective.Normalize {
+				return s.processed()
+			}
+			return float64(s.PPS)
+		}
+		for _, t := range trials[1:] {
+			higher := config.Objective.Normalize || float64(t.sample.PPS) > float64(extDrop)*PPS_THRESHOLD
+			for _, other := range trials {
+				if other.index != t.index && throughput(t.sample) <= throughput(other.sample)*PPS_THRESHOLD {
+					higher = false
+				}
+			}
+			if higher {
+				best = t
+				break
+			}
+		}
+		if best.index != current {
+			p.Printf("%s %s %s is better by (PPS=%d)\n", best.name, knob, values[best.index], int(best.sample.PPS)-int(old.sample.PPS))
+			if !significant(func(s Sample) float64 { return -throughput(s) }) {
+				best = old
+			}
+		} else {
+			p.Printf("Current %s %s is better\n", knob, values[current])
+		}
+	}
+
+	if applied != best.index {
+		next, err := set(config, best.index)
+		if err != nil {
+			return config, best.sample, err
+		}
+		config = next
+	}
+	return config, best.sample, nil
+}
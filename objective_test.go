@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSampleRates(t *testing.T) {
+	tests := []struct {
+		name       string
+		sample     Sample
+		loss       float64
+		cpuPerMpps float64
+		processed  float64
+	}{
+		// 2 core al 50% per 4 Mpps ricevuti
+		{"normal", Sample{PPS: 3000000, InputPPS: 4000000, NotProcessed: 1000000, CPU: 50, CoreCPU: []float64{40, 60}}, 0.25, 25, 0.75},
+		{"all processed", Sample{PPS: 1000000, InputPPS: 1000000, CPU: 30, CoreCPU: []float64{30}}, 0, 30, 1},
+		// l'azione può superare di poco phy, non è una perdita negativa
+		{"action above input", Sample{PPS: 1000100, InputPPS: 1000000, NotProcessed: -100, CPU: 30, CoreCPU: []float64{30}}, 0, 30, 1.0001},
+		// senza traffico in ingresso non c'è costo finito per pacchetto
+		{"idle", Sample{CPU: 1, CoreCPU: []float64{1}}, 0, math.Inf(1), 0},
+	}
+	for _, test := range tests {
+		s := test.sample
+		if got := s.lossRate(); !near(got, test.loss, 1e-12) {
+			t.Errorf("%s: lossRate = %g, want %g", test.name, got, test.loss)
+		}
+		if got := s.cpuPerMpps(); got != test.cpuPerMpps && !near(got, test.cpuPerMpps, 1e-9) {
+			t.Errorf("%s: cpuPerMpps = %g, want %g", test.name, got, test.cpuPerMpps)
+		}
+		if got := s.processed(); !near(got, test.processed, 1e-12) {
+			t.Errorf("%s: processed = %g, want %g", test.name, got, test.processed)
+		}
+	}
+}
+
+func TestNormalizedObjective(t *testing.T) {
+	// stesse prestazioni per pacchetto a carichi diversi
+	low := Sample{PPS: 900000, InputPPS: 1000000, CPU: 20, CoreCPU: []float64{20, 20}}
+	high := Sample{PPS: 1800000, InputPPS: 2000000, CPU: 40, CoreCPU: []float64{40, 40}}
+	tests := []struct {
+		conf ObjectiveConfig
+		same bool
+	}{
+		{ObjectiveConfig{Name: OBJECTIVE_CPU}, false},
+		{ObjectiveConfig{Name: OBJECTIVE_CPU, Normalize: true}, true},
+		{ObjectiveConfig{Name: OBJECTIVE_THROUGHPUT}, false},
+		{ObjectiveConfig{Name: OBJECTIVE_THROUGHPUT, Normalize: true}, true},
+	}
+	for _, test := range tests {
+		o := newObjective(test.conf)
+		if same := near(o.Cost(low), o.Cost(high), 1e-9); same != test.same {
+			t.Errorf("%s normalized %t: cost %g at 1 Mpps and %g at 2 Mpps, want equal %t", test.conf.Name, test.conf.Normalize, o.Cost(low), o.Cost(high), test.same)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const POWERCAP_ROOT = "/sys/class/powercap"
+
+type raplDomain struct {
+	name     string
+	path     string
+	maxRange uint64 // energy_uj wraps around after this value
+}
+
+// rapl reads the package and DRAM energy counters of every socket
+type rapl struct {
+	domains []raplDomain
+}
+
+// energyMeter is nil when RAPL is not available
+var energyMeter *rapl
+
+// newRAPL finds the package and DRAM domains, psys and the core/uncore subdomains would count twice
+func newRAPL(root string) (*rapl, error) {
+	dirs, err := filepath.Glob(filepath.Join(root, "intel-rapl:*"))
+	if err != nil {
+		return nil, err
+	}
+	r := &rapl{}
+	for _, dir := range dirs {
+		name, err := readSysfs(filepath.Join(dir, "name"))
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(name, "package") && name != "dram" {
+			continue
+		}
+		maxRange, err := readUint(filepath.Join(dir, "max_energy_range_uj"), 10)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := readUint(filepath.Join(dir, "energy_uj"), 10); err != nil {
+			return nil, fmt.Errorf("reading RAPL %s: %w", name, err)
+		}
+		r.domains = append(r.domains, raplDomain{name, filepath.Join(dir, "energy_uj"), maxRange})
+	}
+	if len(r.domains) == 0 {
+		return nil, fmt.Errorf("no RAPL package or DRAM domain in %s", root)
+	}
+	return r, nil
+}
+
+// read returns the energy counter of every domain in uJ
+func (r *rapl) read() []uint64 {
+	counters := make([]uint64, len(r.domains))
+	for i, domain := range r.domains {
+		value, err := readUint(domain.path, 10)
+		if err != nil {
+			panic(err.Error())
+		}
+		counters[i] = value
+	}
+	return counters
+}
+
+// joules returns the energy between two reads, handling counters that wrapped around
+func (r *rapl) joules(pre []uint64, post []uint64) float64 {
+	var uj uint64
+	for i, domain := range r.domains {
+		if post[i] >= pre[i] {
+			uj += post[i] - pre[i]
+		} else {
+			uj += domain.maxRange - pre[i] + post[i]
+		}
+	}
+	return float64(uj) / 1e6
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeRAPL builds a powercap tree with two packages, their core subdomain, DRAM and psys
+func fakeRAPL(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	domains := map[string]string{
+		"intel-rapl:0":   "package-0",
+		"intel-rapl:0:0": "core",
+		"intel-rapl:0:1": "dram",
+		"intel-rapl:1":   "package-1",
+		"intel-rapl:2":   "psys",
+	}
+	for dir, name := range domains {
+		files := map[string]string{"name": name, "max_energy_range_uj": "262143328850", "energy_uj": "1000"}
+		for file, content := range files {
+			path := filepath.Join(root, dir, file)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+func TestNewRAPL(t *testing.T) {
+	r, err := newRAPL(fakeRAPL(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// core e psys contano di nuovo energia già nei package
+	var names []string
+	for _, domain := range r.domains {
+		names = append(names, domain.name)
+	}
+	if len(names) != 3 {
+		t.Errorf("domains = %v, want package-0, dram and package-1", names)
+	}
+	for _, domain := range r.domains {
+		if domain.maxRange != 262143328850 {
+			t.Errorf("%s: maxRange = %d, want 262143328850", domain.name, domain.maxRange)
+		}
+	}
+	if got := r.read(); len(got) != 3 || got[0] != 1000 {
+		t.Errorf("read() = %v, want 1000 for every domain", got)
+	}
+}
+
+func TestNewRAPLWithoutDomains(t *testing.T) {
+	if _, err := newRAPL(t.TempDir()); err == nil {
+		t.Errorf("newRAPL on an empty tree succeeded, want an error")
+	}
+}
+
+func TestRAPLJoules(t *testing.T) {
+	r := &rapl{domains: []raplDomain{{"package-0", "", 1000000}, {"dram", "", 500000}}}
+	tests := []struct {
+		name      string
+		pre, post []uint64
+		want      float64
+	}{
+		{"no change", []uint64{100, 100}, []uint64{100, 100}, 0},
+		{"summed", []uint64{100000, 0}, []uint64{600000, 250000}, 0.75},
+		// oltre max_energy_range_uj il contatore riparte da 0
+		{"wrapped", []uint64{900000, 0}, []uint64{100000, 0}, 0.2},
+		{"one wrapped", []uint64{999000, 400000}, []uint64{1000, 100000}, 0.002 + 0.2},
+	}
+	for _, test := range tests {
+		if got := r.joules(test.pre, test.post); !near(got, test.want, 1e-9) {
+			t.Errorf("%s: joules(%v, %v) = %g, want %g", test.name, test.pre, test.post, got, test.want)
+		}
+	}
+}
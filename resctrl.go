@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+)
+
+type ResctrlConfig struct {
+	Enabled bool
+	Root    string // resctrl mount point, usually /sys/fs/resctrl
+	Group   string // control group created for the RX cores
+}
+
+/*
+resctrl drives Intel RDT through the resctrl filesystem: a control group holding the
+RX cores, whose L3 CAT mask is swept like the other knobs, plus the LLC occupancy and
+memory bandwidth of the group read from mon_data. Every path is below root, so it can
+be pointed at a fake tree.
+*/
+type resctrl struct {
+	root    string
+	group   string
+	cbmBits int
+	minBits int
+}
+
+// cacheAlloc is nil when cache allocation is disabled
+var cacheAlloc *resctrl
+
+func readUint(path string, base int) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), base, 64)
+}
+
+// newResctrl creates the control group and moves the RX cores into it
+func newResctrl(conf ResctrlConfig, config Config) (*resctrl, error) {
+	cbmMask, err := readUint(filepath.Join(conf.Root, "info", "L3", "cbm_mask"), 16)
+	if err != nil {
+		return nil, fmt.Errorf("L3 CAT not available: %w", err)
+	}
+	minBits, err := readUint(filepath.Join(conf.Root, "info", "L3", "min_cbm_bits"), 10)
+	if err != nil {
+		return nil, fmt.Errorf("L3 CAT not available: %w", err)
+	}
+
+	r := &resctrl{
+		root:    conf.Root,
+		group:   conf.Group,
+		cbmBits: bits.OnesCount64(cbmMask),
+		minBits: max(int(minBits), 1),
+	}
+	if err := os.Mkdir(r.groupPath(), 0755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("creating resctrl group %s: %w", conf.Group, err)
+	}
+
+	var cpus []string
+	for _, cpu := range config.CoreSet {
+		cpus = append(cpus, strconv.Itoa(cpu))
+	}
+	err = os.WriteFile(filepath.Join(r.groupPath(), "cpus_list"), []byte(strings.Join(cpus, ",")), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("moving RX cores to resctrl group %s: %w", conf.Group, err)
+	}
+	return r, nil
+}
+
+func (r *resctrl) groupPath() string {
+	return filepath.Join(r.root, r.group)
+}
+
+// remove deletes the group, its CPUs go back to the default group
+func (r *resctrl) remove() {
+	if err := os.Remove(r.groupPath()); err != nil {
+		fmt.Printf("Removing resctrl group %s: %s\n", r.group, err)
+	}
+}
+
+// masks returns the contiguous CAT masks from the minimum number of ways to all of them
+func (r *resctrl) masks() []uint64 {
+	var masks []uint64
+	for n := r.minBits; n <= r.cbmBits; n++ {
+		masks = append(masks, (uint64(1)<<n)-1)
+	}
+	return masks
+}
+
+// cacheIDs returns the L3 domains listed in the default group schemata
+func (r *resctrl) cacheIDs() ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(r.root, "schemata"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		domains, ok := strings.CutPrefix(strings.TrimSpace(line), "L3:")
+		if !ok {
+			continue
+		}
+		var ids []string
+		for _, domain := range strings.Split(domains, ";") {
+			id, _, _ := strings.Cut(domain, "=")
+			ids = append(ids, strings.TrimSpace(id))
+		}
+		return ids, nil
+	}
+	return nil, fmt.Errorf("no L3 line in %s", filepath.Join(r.root, "schemata"))
+}
+
+// setMask writes the same mask on every domain, only the RX cores are in the group anyway
+func (r *resctrl) setMask(mask uint64) error {
+	ids, err := r.cacheIDs()
+	if err != nil {
+		return err
+	}
+	var domains []string
+	for _, id := range ids {
+		domains = append(domains, fmt.Sprintf("%s=%x", id, mask))
+	}
+	schemata := "L3:" + strings.Join(domains, ";") + "\n"
+	if err := os.WriteFile(filepath.Join(r.groupPath(), "schemata"), []byte(schemata), 0644); err != nil {
+		return fmt.Errorf("writing L3 mask %x: %w", mask, err)
+	}
+	return nil
+}
+
+// getMask returns the mask of the first domain of the group
+func (r *resctrl) getMask() (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(r.groupPath(), "schemata"))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		domains, ok := strings.CutPrefix(strings.TrimSpace(line), "L3:")
+		if !ok {
+			continue
+		}
+		first, _, _ := strings.Cut(domains, ";")
+		_, mask, _ := strings.Cut(first, "=")
+		return strconv.ParseUint(mask, 16, 64)
+	}
+	return 0, fmt.Errorf("no L3 line in the schemata of %s", r.group)
+}
+
+// sumMonData adds up a monitoring counter over every L3 domain of the group
+func (r *resctrl) sumMonData(event string) (uint64, error) {
+	dirs, err := filepath.Glob(filepath.Join(r.groupPath(), "mon_data", "mon_L3_*"))
+	if err != nil {
+		return 0, err
+	}
+	if len(dirs) == 0 {
+		return 0, fmt.Errorf("no L3 monitoring data for %s", r.group)
+	}
+	var sum uint64
+	for _, dir := range dirs {
+		value, err := readUint(filepath.Join(dir, event), 10)
+		if err != nil {
+			return 0, err
+		}
+		sum += value
+	}
+	return sum, nil
+}
+
+func (r *resctrl) llcOccupancy() (uint64, error) {
+	return r.sumMonData("llc_occupancy")
+}
+
+func (r *resctrl) mbmTotal() (uint64, error) {
+	return r.sumMonData("mbm_total_bytes")
+}
+
+// mbmRate returns the memory bandwidth in MB/s between two reads of mbm_total_bytes, 0 if the counter was reset
+func mbmRate(pre uint64, post uint64, seconds float64) float64 {
+	if post < pre || seconds <= 0 {
+		return 0
+	}
+	return float64(post-pre) / seconds / 1e6
+}
+
+// resctrlStrings returns L3 ways, LLC occupancy in KB and memory bandwidth in MB/s of the sample for results.csv
+func resctrlStrings(config Config, sample Sample) (string, string, string) {
+	if cacheAlloc == nil {
+		return "", "", ""
+	}
+	// senza mon_data le colonne restano vuote, non a 0
+	if _, err := cacheAlloc.llcOccupancy(); err != nil {
+		return fmt.Sprintf("%d", bits.OnesCount64(config.L3Mask)), "", ""
+	}
+	return fmt.Sprintf("%d", bits.OnesCount64(config.L3Mask)), fmt.Sprintf("%d", sample.LLCOccupancy/1024), fmt.Sprintf("%f", sample.MemBandwidth)
+}
+
+// dimension is the L3 CAT mask of the RX cores as searched
+func (r *resctrl) dimension(config Config) Dimension {
+	masks := r.masks()
+	// una maschera fuori lista non e' quella piena, la ricerca parte dalla piu' vicina
+	return Dimension{"l3_mask", formatValues(masks, "%x"), slices.Index(masks, config.L3Mask), fmt.Sprintf("%x", config.L3Mask), 16, func(config Config, index int) (Config, error) {
+		if err := r.setMask(masks[index]); err != nil {
+			return config, err
+		}
+		config.L3Mask = masks[index]
+		return config, nil
+	}}
+}
+
+/*
+test and update the L3 CAT mask of the RX cores, trying one way less and one more
+*/
+func (r *resctrl) changeL3Mask(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, Sample) {
+	config, sample, err := searchNeighbours(ethHandle, config, interval, extDrop, r.dimension(config))
+	if err != nil {
+		panic(err.Error())
+	}
+	return config, sample
+}
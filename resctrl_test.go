@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeResctrl builds a resctrl tree with an 11 way L3 and two cache domains
+func fakeResctrl(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	files := map[string]string{
+		"info/L3/cbm_mask":     "7ff\n",
+		"info/L3/min_cbm_bits": "2\n",
+		"schemata":             "    L3:0=7ff;1=7ff\n  MB:0=100;1=100\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// writeMonData writes the counters of one L3 domain of the group
+func writeMonData(t *testing.T, root string, domain string, occupancy string, mbm string) {
+	t.Helper()
+	dir := filepath.Join(root, "rx", "mon_data", "mon_L3_"+domain)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "llc_occupancy"), []byte(occupancy+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "mbm_total_bytes"), []byte(mbm+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewResctrl(t *testing.T) {
+	root := fakeResctrl(t)
+	r, err := newResctrl(ResctrlConfig{Enabled: true, Root: root, Group: "rx"}, Config{CoreSet: []int{2, 3, 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.cbmBits != 11 || r.minBits != 2 {
+		t.Errorf("cbmBits %d, minBits %d, want 11 and 2", r.cbmBits, r.minBits)
+	}
+	cpus, err := os.ReadFile(filepath.Join(root, "rx", "cpus_list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cpus) != "2,3,5" {
+		t.Errorf("cpus_list %q, want 2,3,5", cpus)
+	}
+
+	// il gruppo esiste gia': si riusa
+	if _, err := newResctrl(ResctrlConfig{Enabled: true, Root: root, Group: "rx"}, Config{CoreSet: []int{2}}); err != nil {
+		t.Errorf("existing group: %s", err)
+	}
+}
+
+func TestNewResctrlWithoutCAT(t *testing.T) {
+	if _, err := newResctrl(ResctrlConfig{Enabled: true, Root: t.TempDir(), Group: "rx"}, Config{}); err == nil {
+		t.Error("no error without info/L3")
+	}
+}
+
+func TestResctrlMasks(t *testing.T) {
+	r := &resctrl{cbmBits: 11, minBits: 2}
+	masks := r.masks()
+	if len(masks) != 10 {
+		t.Fatalf("%d masks, want 10", len(masks))
+	}
+	if masks[0] != 0x3 || masks[len(masks)-1] != 0x7ff {
+		t.Errorf("masks from %x to %x, want 3 to 7ff", masks[0], masks[len(masks)-1])
+	}
+	if !slices.IsSorted(masks) {
+		t.Errorf("masks not sorted: %x", masks)
+	}
+}
+
+func TestResctrlSetMask(t *testing.T) {
+	root := fakeResctrl(t)
+	r, err := newResctrl(ResctrlConfig{Enabled: true, Root: root, Group: "rx"}, Config{CoreSet: []int{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.setMask(0x3f); err != nil {
+		t.Fatal(err)
+	}
+	schemata, err := os.ReadFile(filepath.Join(root, "rx", "schemata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(schemata)) != "L3:0=3f;1=3f" {
+		t.Errorf("schemata %q, want L3:0=3f;1=3f", schemata)
+	}
+	mask, err := r.getMask()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mask != 0x3f {
+		t.Errorf("mask %x, want 3f", mask)
+	}
+}
+
+func TestResctrlMonData(t *testing.T) {
+	root := fakeResctrl(t)
+	r, err := newResctrl(ResctrlConfig{Enabled: true, Root: root, Group: "rx"}, Config{CoreSet: []int{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.llcOccupancy(); err == nil {
+		t.Error("no error without mon_data")
+	}
+	if _, err := r.mbmTotal(); err == nil {
+		t.Error("no error without mon_data")
+	}
+
+	writeMonData(t, root, "00", "1048576", "1000000")
+	writeMonData(t, root, "01", "524288", "3000000")
+	occupancy, err := r.llcOccupancy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if occupancy != 1572864 {
+		t.Errorf("occupancy %d, want 1572864", occupancy)
+	}
+	total, err := r.mbmTotal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4000000 {
+		t.Errorf("mbm_total_bytes %d, want 4000000", total)
+	}
+
+	writeMonData(t, root, "02", "garbage", "0")
+	if _, err := r.llcOccupancy(); err == nil {
+		t.Error("no error on a malformed counter")
+	}
+}
+
+func TestMBMRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		pre, post uint64
+		seconds   float64
+		want      float64
+	}{
+		{"steady", 1000000, 5000000, 2, 2},
+		{"idle", 3000000, 3000000, 1, 0},
+		// contatore azzerato: nessuna banda negativa
+		{"reset", 4000000, 0, 1, 0},
+		{"no time", 0, 1000000, 0, 0},
+	}
+	for _, test := range tests {
+		if got := mbmRate(test.pre, test.post, test.seconds); got != test.want {
+			t.Errorf("%s: mbmRate(%d, %d, %f) = %f, want %f", test.name, test.pre, test.post, test.seconds, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// from include/uapi/linux/ethtool.h
+const (
+	SIOCETHTOOL              = 0x8946
+	ETHTOOL_GRSSH            = 0x00000046
+	ETHTOOL_SRSSH            = 0x00000047
+	ETH_RXFH_INDIR_NO_CHANGE = 0xffffffff
+	ETH_RXFH_CONTEXT_ALLOC   = 0xffffffff
+	ETH_RSS_HASH_NO_CHANGE   = 0
+	RXFH_HEADER_SIZE         = 24 // struct ethtool_rxfh without rss_config[]
+)
+
+// RSS is the RSS configuration of one context, Indir and Key are sized by the driver
+type RSS struct {
+	Context uint32
+	Indir   []uint32
+	Key     []byte
+	HFunc   uint8
+}
+
+type ifreq struct {
+	Name [unix.IFNAMSIZ]byte
+	Data uintptr
+}
+
+// ethtoolIoctl issues SIOCETHTOOL with data as the ethtool command buffer
+func ethtoolIoctl(iface string, data []byte) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, unix.IPPROTO_IP)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	var req ifreq
+	copy(req.Name[:unix.IFNAMSIZ-1], iface)
+	req.Data = uintptr(unsafe.Pointer(&data[0]))
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), SIOCETHTOOL, uintptr(unsafe.Pointer(&req)))
+	runtime.KeepAlive(data)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func encodeRxfh(cmd uint32, context uint32, indirSize uint32, keySize uint32, hfunc uint8) []byte {
+	buf := make([]byte, RXFH_HEADER_SIZE, RXFH_HEADER_SIZE+4*int(indirSize)+int(keySize))
+	binary.NativeEndian.PutUint32(buf[0:], cmd)
+	binary.NativeEndian.PutUint32(buf[4:], context)
+	binary.NativeEndian.PutUint32(buf[8:], indirSize)
+	binary.NativeEndian.PutUint32(buf[12:], keySize)
+	buf[16] = hfunc
+	return buf
+}
+
+// getRSS reads the indirection table, hash key and hash function of an RSS context
+func getRSS(iface string, context uint32) (RSS, error) {
+	// prima chiamata con dimensioni a zero, il driver risponde con quelle vere
+	head := encodeRxfh(ETHTOOL_GRSSH, context, 0, 0, 0)
+	if err := ethtoolIoctl(iface, head); err != nil {
+		return RSS{}, fmt.Errorf("reading RSS sizes of %s: %w", iface, err)
+	}
+	indirSize := binary.NativeEndian.Uint32(head[8:])
+	keySize := binary.NativeEndian.Uint32(head[12:])
+
+	buf := encodeRxfh(ETHTOOL_GRSSH, context, indirSize, keySize, 0)
+	buf = buf[:cap(buf)]
+	if err := ethtoolIoctl(iface, buf); err != nil {
+		return RSS{}, fmt.Errorf("reading RSS of %s: %w", iface, err)
+	}
+
+	rss := RSS{
+		Context: context,
+		Indir:   make([]uint32, indirSize),
+		Key:     make([]byte, keySize),
+		HFunc:   buf[16],
+	}
+	for i := range rss.Indir {
+		rss.Indir[i] = binary.NativeEndian.Uint32(buf[RXFH_HEADER_SIZE+4*i:])
+	}
+	copy(rss.Key, buf[RXFH_HEADER_SIZE+4*int(indirSize):])
+	return rss, nil
+}
+
+/*
+setRSS writes an RSS context. A nil Indir or Key leaves that part unchanged, an empty
+Indir resets the default context and deletes any other one, and a zero HFunc keeps the
+current hash function. It returns the context id, which is the one allocated by the
+driver when rss.Context is ETH_RXFH_CONTEXT_ALLOC.
+*/
+func setRSS(iface string, rss RSS) (uint32, error) {
+	indirSize := uint32(ETH_RXFH_INDIR_NO_CHANGE)
+	if rss.Indir != nil {
+		indirSize = uint32(len(rss.Indir))
+	}
+
+	buf := encodeRxfh(ETHTOOL_SRSSH, rss.Context, indirSize, uint32(len(rss.Key)), rss.HFunc)
+	for _, queue := range rss.Indir {
+		buf = binary.NativeEndian.AppendUint32(buf, queue)
+	}
+	buf = append(buf, rss.Key...)
+
+	if err := ethtoolIoctl(iface, buf); err != nil {
+		return 0, fmt.Errorf("writing RSS of %s: %w", iface, err)
+	}
+	return binary.NativeEndian.Uint32(buf[4:]), nil
+}
+
+/*
+fillIndir spreads the indirection table over the queues proportionally to their
+weight, like ethtool -X weight does (port of fill_indir_table from ethtool.c)
+*/
+func fillIndir(size uint32, weights []uint32) ([]uint32, error) {
+	var sum uint64
+	for _, weight := range weights {
+		sum += uint64(weight)
+	}
+	if sum == 0 {
+		return nil, fmt.Errorf("at least one weight must be non-zero")
+	}
+	if sum > uint64(size) {
+		return nil, fmt.Errorf("total weight %d exceeds the size %d of the indirection table", sum, size)
+	}
+
+	indir := make([]uint32, size)
+	var partial uint64
+	queue := -1
+	for i := uint64(0); i < uint64(size); i++ {
+		for i >= uint64(size)*partial/sum {
+			queue++
+			partial += uint64(weights[queue])
+		}
+		indir[i] = uint32(queue)
+	}
+	return indir, nil
+}
+
+// from include/uapi/linux/ethtool.h
+const (
+	ETHTOOL_GRXFH = 0x00000029
+	ETHTOOL_SRXFH = 0x0000002a
+
+	TCP_V4_FLOW = 0x01
+	UDP_V4_FLOW = 0x02
+	TCP_V6_FLOW = 0x05
+	UDP_V6_FLOW = 0x06
+	FLOW_RSS    = 0x20000000
+
+	RXH_L2DA     = 1 << 1
+	RXH_VLAN     = 1 << 2
+	RXH_L3_PROTO = 1 << 3
+	RXH_IP_SRC   = 1 << 4
+	RXH_IP_DST   = 1 << 5
+	RXH_L4_B_0_1 = 1 << 6
+	RXH_L4_B_2_3 = 1 << 7
+
+	ETHTOOL_SRXCLSRLDEL = 0x00000031
+	ETHTOOL_SRXCLSRLINS = 0x00000032
+
+	RXNFC_SIZE = 192 // struct ethtool_rxnfc without rule_locs[]
+
+	// offsets of struct ethtool_rx_flow_spec fields inside struct ethtool_rxnfc
+	RXNFC_FS_FLOW_TYPE   = 16
+	RXNFC_FS_H_U         = 20
+	RXNFC_FS_M_U         = 92
+	RXNFC_FS_RING_COOKIE = 168
+	RXNFC_FS_LOCATION    = 176
+	RXNFC_RSS_CONTEXT    = 184
+)
+
+// flow types accepted by ethtool -N rx-flow-hash
+var flowTypes = map[string]uint32{
+	"tcp4": TCP_V4_FLOW,
+	"udp4": UDP_V4_FLOW,
+	"tcp6": TCP_V6_FLOW,
+	"udp6": UDP_V6_FLOW,
+}
+
+func encodeRxnfc(cmd uint32, flowType uint32, data uint64) []byte {
+	buf := make([]byte, RXNFC_SIZE)
+	binary.NativeEndian.PutUint32(buf[0:], cmd)
+	binary.NativeEndian.PutUint32(buf[4:], flowType)
+	binary.NativeEndian.PutUint64(buf[8:], data)
+	return buf
+}
+
+// getHashFields returns the RXH_* fields hashed for a flow type
+func getHashFields(iface string, flowType string) (uint64, error) {
+	flow, ok := flowTypes[flowType]
+	if !ok {
+		return 0, fmt.Errorf("unknown flow type %s", flowType)
+	}
+	buf := encodeRxnfc(ETHTOOL_GRXFH, flow, 0)
+	if err := ethtoolIoctl(iface, buf); err != nil {
+		return 0, fmt.Errorf("reading %s hash fields of %s: %w", flowType, iface, err)
+	}
+	return binary.NativeEndian.Uint64(buf[8:]), nil
+}
+
+func setHashFields(iface string, flowType string, fields uint64) error {
+	flow, ok := flowTypes[flowType]
+	if !ok {
+		return fmt.Errorf("unknown flow type %s", flowType)
+	}
+	buf := encodeRxnfc(ETHTOOL_SRXFH, flow, fields)
+	if err := ethtoolIoctl(iface, buf); err != nil {
+		return fmt.Errorf("writing %s hash fields of %s: %w", flowType, iface, err)
+	}
+	return nil
+}
+
+// hashFieldsString formats RXH_* fields with the letters used by ethtool -N rx-flow-hash
+func hashFieldsString(fields uint64) string {
+	var s string
+	for _, f := range []struct {
+		bit    uint64
+		letter string
+	}{{RXH_L2DA, "m"}, {RXH_VLAN, "v"}, {RXH_L3_PROTO, "t"}, {RXH_IP_SRC, "s"}, {RXH_IP_DST, "d"}, {RXH_L4_B_0_1, "f"}, {RXH_L4_B_2_3, "n"}} {
+		if fields&f.bit != 0 {
+			s += f.letter
+		}
+	}
+	return s
+}
+
+func getRSSKey(iface string) ([]byte, error) {
+	rss, err := getRSS(iface, 0)
+	if err != nil {
+		return nil, fmt.Errorf("reading the RSS key of %s: %w", iface, err)
+	}
+	return rss.Key, nil
+}
+
+func setRSSKey(iface string, key []byte) error {
+	if _, err := setRSS(iface, RSS{Key: key}); err != nil {
+		return fmt.Errorf("writing the RSS key of %s: %w", iface, err)
+	}
+	return nil
+}
+
+/*
+NtupleRule steers TCP or UDP over IPv4 to a queue, addresses and ports in network order.
+Zero fields are wildcards. With a Context the queue is relative to the queues of that
+RSS context and the flow is spread by its indirection table.
+*/
+type NtupleRule struct {
+	Location uint32
+	Proto    uint8
+	SrcIP    [4]byte
+	DstIP    [4]byte
+	SrcPort  [2]byte
+	DstPort  [2]byte
+	Queue    uint32
+	Context  uint32
+}
+
+func maskOf(field []byte) []byte {
+	mask := make([]byte, len(field))
+	for i := range field {
+		if field[i] != 0 {
+			// un campo non nullo va confrontato per intero
+			for j := range mask {
+				mask[j] = 0xff
+			}
+			break
+		}
+	}
+	return mask
+}
+
+// insertNtupleRule installs a flow director rule at rule.Location, like ethtool -N flow-type
+func insertNtupleRule(iface string, rule NtupleRule) error {
+	var flow uint32
+	switch rule.Proto {
+	case unix.IPPROTO_TCP:
+		flow = TCP_V4_FLOW
+	case unix.IPPROTO_UDP:
+		flow = UDP_V4_FLOW
+	default:
+		return fmt.Errorf("cannot steer protocol %d", rule.Proto)
+	}
+
+	buf := encodeRxnfc(ETHTOOL_SRXCLSRLINS, 0, 0)
+	if rule.Context != 0 {
+		flow |= FLOW_RSS
+		binary.NativeEndian.PutUint32(buf[RXNFC_RSS_CONTEXT:], rule.Context)
+	}
+	binary.NativeEndian.PutUint32(buf[RXNFC_FS_FLOW_TYPE:], flow)
+	// struct ethtool_tcpip4_spec: ip4src, ip4dst, psrc, pdst, tos
+	copy(buf[RXNFC_FS_H_U:], rule.SrcIP[:])
+	copy(buf[RXNFC_FS_H_U+4:], rule.DstIP[:])
+	copy(buf[RXNFC_FS_H_U+8:], rule.SrcPort[:])
+	copy(buf[RXNFC_FS_H_U+10:], rule.DstPort[:])
+	copy(buf[RXNFC_FS_M_U:], maskOf(rule.SrcIP[:]))
+	copy(buf[RXNFC_FS_M_U+4:], maskOf(rule.DstIP[:]))
+	copy(buf[RXNFC_FS_M_U+8:], maskOf(rule.SrcPort[:]))
+	copy(buf[RXNFC_FS_M_U+10:], maskOf(rule.DstPort[:]))
+	binary.NativeEndian.PutUint64(buf[RXNFC_FS_RING_COOKIE:], uint64(rule.Queue))
+	binary.NativeEndian.PutUint32(buf[RXNFC_FS_LOCATION:], rule.Location)
+
+	if err := ethtoolIoctl(iface, buf); err != nil {
+		return fmt.Errorf("inserting rule %d on %s: %w", rule.Location, iface, err)
+	}
+	return nil
+}
+
+func deleteNtupleRule(iface string, location uint32) error {
+	buf := encodeRxnfc(ETHTOOL_SRXCLSRLDEL, 0, 0)
+	binary.NativeEndian.PutUint32(buf[RXNFC_FS_LOCATION:], location)
+	if err := ethtoolIoctl(iface, buf); err != nil {
+		return fmt.Errorf("deleting rule %d on %s: %w", location, iface, err)
+	}
+	return nil
+}
+
+// createRSSContext allocates a new RSS context spreading traffic over queues
+func createRSSContext(iface string, queues []uint32) (uint32, error) {
+	if len(queues) == 0 {
+		return 0, fmt.Errorf("an RSS context needs at least one queue")
+	}
+	def, err := getRSS(iface, 0)
+	if err != nil {
+		return 0, err
+	}
+	indir := make([]uint32, len(def.Indir))
+	for i := range indir {
+		indir[i] = queues[i%len(queues)]
+	}
+	return setRSS(iface, RSS{Context: ETH_RXFH_CONTEXT_ALLOC, Indir: indir})
+}
+
+func deleteRSSContext(iface string, context uint32) error {
+	_, err := setRSS(iface, RSS{Context: context, Indir: []uint32{}})
+	return err
+}
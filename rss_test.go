@@ -0,0 +1,86 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFillIndir(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    uint32
+		weights []uint32
+		want    []uint32
+	}{
+		{"equal", 4, []uint32{1, 1}, []uint32{0, 0, 1, 1}},
+		{"proportional", 8, []uint32{3, 1}, []uint32{0, 0, 0, 0, 0, 0, 1, 1}},
+		// una coda con peso 0 non riceve righe
+		{"zero weight", 4, []uint32{1, 0, 1}, []uint32{0, 0, 2, 2}},
+		{"single queue", 4, []uint32{0, 1}, []uint32{1, 1, 1, 1}},
+		// come ethtool, la riga a cavallo del confine va alla coda dopo
+		{"uneven", 5, []uint32{1, 1}, []uint32{0, 0, 1, 1, 1}},
+		{"weight equal to size", 4, []uint32{1, 1, 1, 1}, []uint32{0, 1, 2, 3}},
+	}
+	for _, test := range tests {
+		got, err := fillIndir(test.size, test.weights)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: fillIndir(%d, %v) = %v, want %v", test.name, test.size, test.weights, got, test.want)
+		}
+	}
+}
+
+func TestFillIndirInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    uint32
+		weights []uint32
+	}{
+		{"no weight", 4, []uint32{0, 0}},
+		{"empty", 4, nil},
+		{"weight above size", 2, []uint32{2, 1}},
+	}
+	for _, test := range tests {
+		if indir, err := fillIndir(test.size, test.weights); err == nil {
+			t.Errorf("%s: fillIndir(%d, %v) = %v, want an error", test.name, test.size, test.weights, indir)
+		}
+	}
+}
+
+func TestHashFieldsString(t *testing.T) {
+	tests := []struct {
+		fields uint64
+		want   string
+	}{
+		// le lettere di ethtool -n rx-flow-hash
+		{RXH_IP_SRC | RXH_IP_DST, "sd"},
+		{RXH_IP_SRC | RXH_IP_DST | RXH_L4_B_0_1 | RXH_L4_B_2_3, "sdfn"},
+		{RXH_L2DA | RXH_VLAN | RXH_L3_PROTO, "mvt"},
+		{0, ""},
+	}
+	for _, test := range tests {
+		if got := hashFieldsString(test.fields); got != test.want {
+			t.Errorf("hashFieldsString(%#x) = %q, want %q", test.fields, got, test.want)
+		}
+	}
+}
+
+func TestMaskOf(t *testing.T) {
+	tests := []struct {
+		field []byte
+		want  []byte
+	}{
+		// un campo a zero non si confronta, uno non nullo per intero
+		{[]byte{0, 0, 0, 0}, []byte{0, 0, 0, 0}},
+		{[]byte{10, 0, 0, 1}, []byte{0xff, 0xff, 0xff, 0xff}},
+		{[]byte{0, 80}, []byte{0xff, 0xff}},
+	}
+	for _, test := range tests {
+		if got := maskOf(test.field); !slices.Equal(got, test.want) {
+			t.Errorf("maskOf(%v) = %v, want %v", test.field, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+const (
+	SCALING_AUTO      = "auto"      // rebalance skewed traffic, scale otherwise
+	SCALING_SCALE     = "scale"     // only add or remove cores
+	SCALING_REBALANCE = "rebalance" // only move indirection entries between cores
+)
+
+type ScalingConfig struct {
+	Mode          string
+	HighWatermark float64       // average CPU above which a core is added
+	LowWatermark  float64       // average CPU below which a core is removed
+	SkewThreshold float64       // max-min per core CPU spread that counts as skewed traffic
+	MinCores      uint32        // never scale below
+	MaxCores      uint32        // never scale above
+	Cooldown      time.Duration // minimum time between two changes
+	UseChannels   bool          // scale real channels instead of indirection weights
+	HashAfter     int           // rebalances of still skewed traffic before trying hash fields and key, 0 disables
+	HashFlowTypes []string      // flow types whose hash fields may get the L4 ports, e.g. udp4
+}
+
+// coreScaler keeps the state the controller needs between two rounds
+type coreScaler struct {
+	conf       ScalingConfig
+	lastChange time.Time
+	rebalances int               // consecutive rebalances with traffic still skewed
+	hashFields map[string]uint64 // hash fields of the flow types before rebalanceHash changed them
+	rssKey     []byte            // RSS key before rebalanceHash changed it, nil if it did not
+}
+
+func newCoreScaler(ethHandle *ethtool.Ethtool, config Config) *coreScaler {
+	conf := config.Scaling
+	if conf.Mode == "" {
+		conf.Mode = SCALING_AUTO
+	}
+	if conf.MinCores == 0 {
+		conf.MinCores = 1
+	}
+	// senza set-channels si possono usare solo i canali gia' esistenti
+	channels := getChannels(ethHandle, config.Iface)
+	maxChannels := channels.CombinedCount
+	if conf.UseChannels {
+		maxChannels = channels.MaxCombined
+	}
+	if first, ok := reservedQueues(config); ok {
+		maxChannels = min(maxChannels, first)
+	}
+	if conf.MaxCores == 0 || conf.MaxCores > maxChannels {
+		conf.MaxCores = maxChannels
+	}
+	if conf.MaxCores > uint32(len(config.CoreSet)) {
+		conf.MaxCores = uint32(len(config.CoreSet))
+	}
+	if conf.LowWatermark >= conf.HighWatermark {
+		panic(fmt.Sprintf("low watermark %f must be below high watermark %f", conf.LowWatermark, conf.HighWatermark))
+	}
+	s := &coreScaler{conf: conf, hashFields: make(map[string]uint64)}
+	atShutdown(func() { s.restoreHash(config.Iface) })
+	return s
+}
+
+func (s *coreScaler) setCores(ethHandle *ethtool.Ethtool, config Config, cores uint32) Config {
+	if s.conf.UseChannels {
+		// stesso nome della dimensione, vale lo stesso Settle.Delays
+		config, _ = reconfigure(ethHandle, config, "cores", fmt.Sprintf("%d", config.Cores), fmt.Sprintf("%d", cores), func() (Config, error) {
+			return applyChannels(ethHandle, config, cores), nil
+		}, func(next Config) {
+			settleKnob(ethHandle, next, "cores")
+		})
+		return config
+	}
+	config.Cores = cores
+	config.Weight = createSlice(cores, 0)
+	setIndir(config)
+	return config
+}
+
+// dimension is the number of RX cores as searched, from MinCores to MaxCores
+func (s *coreScaler) dimension(ethHandle *ethtool.Ethtool, config Config) Dimension {
+	var listCores []uint32
+	for cores := s.conf.MinCores; cores <= s.conf.MaxCores; cores++ {
+		listCores = append(listCores, cores)
+	}
+	return Dimension{"cores", formatValues(listCores, "%d"), slices.Index(listCores, config.Cores), fmt.Sprintf("%d", config.Cores), 10, func(config Config, index int) (Config, error) {
+		// reconfigure viene gia' chiamato da chi applica la dimensione
+		if s.conf.UseChannels {
+			return applyChannels(ethHandle, config, listCores[index]), nil
+		}
+		return s.setCores(ethHandle, config, listCores[index]), nil
+	}}
+}
+
+/*
+changeCPUCount adds or removes RX cores, or rebalances the indirection table when
+the traffic is skewed, based on the per core utilization. Changes are rate limited
+by the cooldown and a scale down only happens if the remaining cores are expected to
+stay below the high watermark, so the controller does not flap between the two.
+A change that breaks the constraints is reverted.
+*/
+func (s *coreScaler) changeCPUCount(ethHandle *ethtool.Ethtool, config Config, interval int, extDrop uint64) (Config, Sample) {
+	p := message.NewPrinter(language.English)
+
+	oldConfig := config
+	old := measure(ethHandle, config, interval)
+	percentages := old.CoreCPU
+	if len(percentages) == 0 {
+		return config, old
+	}
+
+	var sum float64
+	for _, percent := range percentages {
+		sum += percent
+	}
+	avg := old.CPU
+
+	if since := time.Since(s.lastChange); since < s.conf.Cooldown {
+		p.Printf("Core scaling in cooldown for %s more\n", (s.conf.Cooldown - since).Round(time.Second))
+		return config, old
+	}
+
+	// le posizioni in CoreCPU non sono code: un core senza lettura viene saltato
+	maxPercent := slices.Max(percentages)
+	maxQueue := old.CoreQueues[slices.Index(percentages, maxPercent)]
+	minPercent := slices.Min(percentages)
+	minQueue := old.CoreQueues[slices.Index(percentages, minPercent)]
+	skewed := maxPercent-minPercent > s.conf.SkewThreshold && len(percentages) > 1
+
+	if !skewed {
+		s.rebalances = 0
+	}
+
+	//traffic skewed
+	if skewed && s.conf.Mode != SCALING_SCALE && (maxPercent > s.conf.HighWatermark || s.conf.Mode == SCALING_REBALANCE) {
+		s.lastChange = time.Now()
+		// la tabella da sola non basta, si prova a cambiare cosa entra nell'hash
+		if s.conf.HashAfter > 0 && s.rebalances >= s.conf.HashAfter {
+			s.rebalances = 0
+			if s.rebalanceHash(ethHandle, config, interval) {
+				return config, old
+			}
+		}
+		p.Printf("Traffic skewed, moving entries from queue %d (%f) to queue %d (%f)\n", maxQueue, maxPercent, minQueue, minPercent)
+		equalizeIndir(config, minQueue, maxQueue)
+		s.rebalances++
+		return config, old
+	}
+	if s.conf.Mode == SCALING_REBALANCE {
+		return config, old
+	}
+
+	if avg > s.conf.HighWatermark && config.Cores < s.conf.MaxCores {
+		p.Printf("Average CPU %f above %f, adding core %d\n", avg, s.conf.HighWatermark, config.Cores)
+		config = s.setCores(ethHandle, config, config.Cores+1)
+	} else if avg < s.conf.LowWatermark && config.Cores > s.conf.MinCores {
+		// stima del carico sui core rimasti, evita di scendere per poi risalire subito
+		expected := sum / float64(config.Cores-1)
+		if expected > s.conf.HighWatermark {
+			p.Printf("Average CPU %f below %f but %d cores would reach %f, keeping %d\n", avg, s.conf.LowWatermark, config.Cores-1, expected, config.Cores)
+			return config, old
+		}
+		p.Printf("Average CPU %f below %f, removing core %d\n", avg, s.conf.LowWatermark, config.Cores-1)
+		config = s.setCores(ethHandle, config, config.Cores-1)
+	} else {
+		return config, old
+	}
+	s.lastChange = time.Now()
+
+	sample := measure(ethHandle, config, interval)
+
+	// un cambio che viola i vincoli viene annullato, se prima li rispettava o ha peggiorato le perdite
+	if !feasible(config, sample) && (feasible(config, old) || sample.NotProcessed > old.NotProcessed) {
+		p.Printf("Cores %d worse than %d, reverting\n", config.Cores, oldConfig.Cores)
+		config = s.setCores(ethHandle, config, oldConfig.Cores)
+		return config, old
+	}
+	p.Printf("Cores %d kept (PPS=%d) (CPU=%f)\n", config.Cores, sample.PPS, sample.CPU)
+	return config, sample
+}
+
+// coreSpread returns the difference between the busiest and the idlest RX core of a sample
+func coreSpread(sample Sample) float64 {
+	if len(sample.CoreCPU) < 2 {
+		return 0
+	}
+	return slices.Max(sample.CoreCPU) - slices.Min(sample.CoreCPU)
+}
+
+func coreSpreads(samples []Sample) []float64 {
+	var spreads []float64
+	for _, sample := range samples {
+		spreads = append(spreads, coreSpread(sample))
+	}
+	return spreads
+}
+
+/*
+rebalanceHash is tried when rebalancing the indirection table did not fix the skew.
+It first adds the L4 ports to the hash of the configured flow types, then tries a
+new random hash key, and keeps the first change that lowers the per queue spread
+significantly, comparing sub-windows before and after with a Welch t-test. The
+original fields and key are saved before the first change, restoreHash puts them back.
+*/
+func (s *coreScaler) rebalanceHash(ethHandle *ethtool.Ethtool, config Config, interval int) bool {
+	p := message.NewPrinter(language.English)
+	l4 := uint64(RXH_L4_B_0_1 | RXH_L4_B_2_3)
+
+	_, before := measureSamples(ethHandle, config, interval, config.Significance.SubWindows)
+	baseline := coreSpreads(before)
+	lower := func(change string) bool {
+		_, after := measureSamples(ethHandle, config, interval, config.Significance.SubWindows)
+		spreads := coreSpreads(after)
+		pValue, effect := welchTest(baseline, spreads)
+		p.Printf("%s: spread from %f to %f, p-value %f, effect size %f\n", change, mean(baseline), mean(spreads), pValue, effect)
+		return mean(spreads) < mean(baseline) && pValue < config.Significance.Alpha
+	}
+
+	for _, flowType := range s.conf.HashFlowTypes {
+		fields, err := getHashFields(config.Iface, flowType)
+		if err != nil {
+			p.Printf("%s\n", err)
+			continue
+		}
+		if fields&l4 == l4 {
+			continue
+		}
+		if _, ok := s.hashFields[flowType]; !ok {
+			s.hashFields[flowType] = fields
+		}
+		if err := setHashFields(config.Iface, flowType, fields|l4); err != nil {
+			p.Printf("%s\n", err)
+			continue
+		}
+		if lower(fmt.Sprintf("Hashing %s on %s", flowType, hashFieldsString(fields|l4))) {
+			return true
+		}
+		p.Printf("Reverting %s to %s\n", flowType, hashFieldsString(fields))
+		if err := setHashFields(config.Iface, flowType, fields); err != nil {
+			p.Printf("%s\n", err)
+			return false
+		}
+	}
+
+	oldKey, err := getRSSKey(config.Iface)
+	if err != nil {
+		p.Printf("%s\n", err)
+		return false
+	}
+	if len(oldKey) == 0 {
+		return false
+	}
+	if s.rssKey == nil {
+		s.rssKey = oldKey
+	}
+	newKey := make([]byte, len(oldKey))
+	if _, err := rand.Read(newKey); err != nil {
+		p.Printf("%s\n", err)
+		return false
+	}
+	if err := setRSSKey(config.Iface, newKey); err != nil {
+		// la scrittura puo' essere fallita a meta', si rimette la chiave di prima
+		p.Printf("%s\n", err)
+		if err := setRSSKey(config.Iface, oldKey); err != nil {
+			p.Printf("%s\n", err)
+		}
+		return false
+	}
+	if lower(fmt.Sprintf("New RSS key %x", newKey)) {
+		return true
+	}
+	p.Printf("Reverting the RSS key\n")
+	if err := setRSSKey(config.Iface, oldKey); err != nil {
+		p.Printf("%s\n", err)
+	}
+	return false
+}
+
+// restoreHash puts back the hash fields and the key rebalanceHash found at start
+func (s *coreScaler) restoreHash(iface string) {
+	for flowType, fields := range s.hashFields {
+		if err := setHashFields(iface, flowType, fields); err != nil {
+			fmt.Printf("Restoring the hash fields of %s: %s\n", flowType, err)
+		}
+	}
+	if s.rssKey != nil {
+		if err := setRSSKey(iface, s.rssKey); err != nil {
+			fmt.Printf("Restoring the RSS key: %s\n", err)
+		}
+	}
+}
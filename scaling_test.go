@@ -0,0 +1,30 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCoreSpread(t *testing.T) {
+	tests := []struct {
+		name string
+		cpu  []float64
+		want float64
+	}{
+		{"skewed", []float64{20, 90, 45}, 70},
+		{"balanced", []float64{50, 50}, 0},
+		// con un core solo non c'è sbilanciamento
+		{"single core", []float64{80}, 0},
+		{"no cores", nil, 0},
+	}
+	for _, test := range tests {
+		if got := coreSpread(Sample{CoreCPU: test.cpu}); got != test.want {
+			t.Errorf("%s: coreSpread(%v) = %g, want %g", test.name, test.cpu, got, test.want)
+		}
+	}
+
+	samples := []Sample{{CoreCPU: []float64{10, 30}}, {CoreCPU: []float64{5}}}
+	if got := coreSpreads(samples); !slices.Equal(got, []float64{20, 0}) {
+		t.Errorf("coreSpreads = %v, want [20 0]", got)
+	}
+}
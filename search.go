@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+const (
+	SEARCH_NEIGHBOUR  = "neighbour"  // one knob at a time, the values next to the current one
+	SEARCH_GRID       = "grid"       // every combination in order
+	SEARCH_RANDOM     = "random"     // uniformly random combinations
+	SEARCH_COORDINATE = "coordinate" // one knob at a time, step doubled on success and halved on failure
+	SEARCH_ANNEALING  = "annealing"  // random moves, worse ones accepted less and less
+)
+
+type SearchConfig struct {
+	Strategy       string
+	MaxEvaluations int     // configurations a joint strategy measures per round
+	Seed           int64   // 0 seeds from the clock
+	Temperature    float64 // initial annealing temperature, as relative cost increase
+	Cooling        float64 // factor the temperature is multiplied by after every move
+	InitialPoints  int     // configurations the Bayesian search measures before trusting its model
+	LengthScale    float64 // of the GP kernel, on knobs scaled to [0, 1]
+	Noise          float64 // variance of the measurement noise, on standardized costs
+}
+
+// Dimension is a knob as the searches see it, the values indexed from 0
+type Dimension struct {
+	Name    string
+	Values  []string
+	Current int    // index of the value set now, -1 when it is none of them
+	Value   string // value set now, formatted like Values
+	Base    int    // of the numbers in Values, 16 for registers and masks, Constraints.Ranges compare the number
+	Apply   func(Config, int) (Config, error)
+}
+
+/*
+nearest returns Current, or with a value that is none of Values the index of the closest
+one. Values are compared as numbers in Base, and the first one is used when they are not
+numbers at all.
+*/
+func (d Dimension) nearest() int {
+	if d.Current >= 0 {
+		return d.Current
+	}
+	base := d.Base
+	if base == 0 {
+		base = 10
+	}
+	value, err := strconv.ParseUint(d.Value, base, 64)
+	if err != nil {
+		return 0
+	}
+	nearest, distance := -1, uint64(0)
+	for i, v := range d.Values {
+		number, err := strconv.ParseUint(v, base, 64)
+		if err != nil {
+			return 0
+		}
+		diff := max(number, value) - min(number, value)
+		if nearest < 0 || diff < distance {
+			nearest, distance = i, diff
+		}
+	}
+	return max(nearest, 0)
+}
+
+/*
+SearchStrategy proposes points of the joint space of the enabled knobs, a point being
+the index of a value for every dimension, and learns from how they did.
+*/
+type SearchStrategy interface {
+	Name() string
+	// Next returns the point to measure, nil when the strategy has nothing left to try
+	Next() []int
+	// Observe reports the sample of the point the last Next returned
+	Observe(point []int, sample Sample)
+}
+
+// optimumPredictor is a strategy with a model of the space, that can predict the optimum
+type optimumPredictor interface {
+	// Predicted returns the predicted optimum, its cost and its probability of being feasible
+	Predicted() ([]int, float64, float64, bool)
+}
+
+func newSearchStrategy(config Config, space []Dimension, start []int) SearchStrategy {
+	conf := config.Search
+	seed := conf.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	switch conf.Strategy {
+	case SEARCH_GRID:
+		return &gridSearch{config: config, space: space}
+	case SEARCH_RANDOM:
+		return &randomSearch{config: config, space: space, rng: rng}
+	case SEARCH_COORDINATE:
+		steps := make([]int, len(space))
+		for i, d := range space {
+			steps[i] = max(len(d.Values)/4, 1)
+		}
+		return &coordinateDescent{config: config, space: space, current: slices.Clone(start), steps: steps}
+	case SEARCH_ANNEALING:
+		return &annealing{config: config, space: space, rng: rng, current: slices.Clone(start), temperature: conf.Temperature, cooling: conf.Cooling}
+	case SEARCH_BAYESIAN:
+		return &bayesianSearch{config: config, space: space, rng: rng, start: slices.Clone(start)}
+	}
+	panic(fmt.Sprintf("unknown search strategy %q", conf.Strategy))
+}
+
+// better reports whether a beats b: feasible first, then lower cost, among infeasible ones more processed
+func better(config Config, a Sample, b Sample) bool {
+	feasibleA, feasibleB := feasible(config, a), feasible(config, b)
+	if feasibleA != feasibleB {
+		return feasibleA
+	}
+	if feasibleA {
+		return objective.Cost(a) < objective.Cost(b)
+	}
+	return a.processed() > b.processed()
+}
+
+// worsening returns how much worse to is than from, relative to the cost of from, 0 if it is not worse
+func worsening(config Config, from Sample, to Sample) float64 {
+	if !better(config, from, to) {
+		return 0
+	}
+	feasibleFrom, feasibleTo := feasible(config, from), feasible(config, to)
+	switch {
+	case feasibleFrom && feasibleTo:
+		cost := objective.Cost(from)
+		return (objective.Cost(to) - cost) / math.Max(math.Abs(cost), 1e-9)
+	case !feasibleFrom && !feasibleTo:
+		return from.processed() - to.processed()
+	}
+	// perdere la fattibilita' pesa come raddoppiare il costo
+	return 1
+}
+
+// allowedPoint reports whether every value of the point is in its allowed range
+func allowedPoint(config Config, space []Dimension, point []int) bool {
+	for i, d := range space {
+		if !allowed(config, d, point[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func pointString(space []Dimension, point []int) string {
+	var values []string
+	for i, d := range space {
+		values = append(values, fmt.Sprintf("%s=%s", d.Name, d.Values[point[i]]))
+	}
+	return strings.Join(values, ";")
+}
+
+// gridSearch walks every combination, the last dimension changing fastest
+type gridSearch struct {
+	config  Config
+	space   []Dimension
+	next    []int
+	started bool
+}
+
+func (g *gridSearch) Name() string { return SEARCH_GRID }
+
+func (g *gridSearch) Next() []int {
+	for {
+		if !g.started {
+			g.next = make([]int, len(g.space))
+			g.started = true
+		} else if !g.increment() {
+			return nil
+		}
+		if allowedPoint(g.config, g.space, g.next) {
+			return slices.Clone(g.next)
+		}
+	}
+}
+
+// increment moves to the next combination, false after the last one
+func (g *gridSearch) increment() bool {
+	for i := len(g.space) - 1; i >= 0; i-- {
+		g.next[i]++
+		if g.next[i] < len(g.space[i].Values) {
+			return true
+		}
+		g.next[i] = 0
+	}
+	return false
+}
+
+func (g *gridSearch) Observe(point []int, sample Sample) {}
+
+type randomSearch struct {
+	config Config
+	space  []Dimension
+	rng    *rand.Rand
+}
+
+func (r *randomSearch) Name() string { return SEARCH_RANDOM }
+
+func (r *randomSearch) Next() []int {
+	point := make([]int, len(r.space))
+	for range 100 {
+		for i, d := range r.space {
+			point[i] = r.rng.Intn(len(d.Values))
+		}
+		if allowedPoint(r.config, r.space, point) {
+			return point
+		}
+	}
+	return nil
+}
+
+func (r *randomSearch) Observe(point []int, sample Sample) {}
+
+/*
+coordinateDescent moves one dimension at a time by its step in both directions. A move
+that improves is taken and doubles the step, none improving halves it, and the search
+is over once every step is down to 0.
+*/
+type coordinateDescent struct {
+	config   Config
+	space    []Dimension
+	current  []int
+	best     Sample
+	measured bool
+	steps    []int
+	dim      int
+	queue    [][]int // points left to try on dim
+}
+
+func (c *coordinateDescent) Name() string { return SEARCH_COORDINATE }
+
+func (c *coordinateDescent) Next() []int {
+	if !c.measured {
+		return slices.Clone(c.current)
+	}
+	for len(c.queue) == 0 {
+		if !slices.ContainsFunc(c.steps, func(step int) bool { return step > 0 }) {
+			return nil
+		}
+		d := c.dim
+		for _, delta := range []int{c.steps[d], -c.steps[d]} {
+			index := min(max(c.current[d]+delta, 0), len(c.space[d].Values)-1)
+			point := slices.Clone(c.current)
+			point[d] = index
+			if index != c.current[d] && allowedPoint(c.config, c.space, point) {
+				c.queue = append(c.queue, point)
+			}
+		}
+		if len(c.queue) == 0 {
+			c.steps[d] = 0
+			c.dim = (d + 1) % len(c.space)
+		}
+	}
+	return slices.Clone(c.queue[0])
+}
+
+func (c *coordinateDescent) Observe(point []int, sample Sample) {
+	if !c.measured {
+		c.best = sample
+		c.measured = true
+		return
+	}
+	d := c.dim
+	c.queue = c.queue[1:]
+	if better(c.config, sample, c.best) {
+		c.current, c.best = point, sample
+		c.steps[d] = min(c.steps[d]*2, max(len(c.space[d].Values)-1, 1))
+		c.queue = nil
+		c.dim = (d + 1) % len(c.space)
+	} else if len(c.queue) == 0 {
+		c.steps[d] /= 2
+		c.dim = (d + 1) % len(c.space)
+	}
+}
+
+/*
+annealing moves one random dimension by one or two values. A better point is always
+taken, a worse one with probability exp(-worsening/temperature), and the temperature
+cools after every move so the search settles on the end.
+*/
+type annealing struct {
+	config      Config
+	space       []Dimension
+	rng         *rand.Rand
+	current     []int
+	sample      Sample
+	measured    bool
+	temperature float64
+	cooling     float64
+}
+
+func (a *annealing) Name() string { return SEARCH_ANNEALING }
+
+func (a *annealing) Next() []int {
+	if !a.measured {
+		return slices.Clone(a.current)
+	}
+	for range 100 {
+		d := a.rng.Intn(len(a.space))
+		delta := a.rng.Intn(2) + 1
+		if a.rng.Intn(2) == 0 {
+			delta = -delta
+		}
+		point := slices.Clone(a.current)
+		point[d] = min(max(point[d]+delta, 0), len(a.space[d].Values)-1)
+		if point[d] != a.current[d] && allowedPoint(a.config, a.space, point) {
+			return point
+		}
+	}
+	return nil
+}
+
+func (a *annealing) Observe(point []int, sample Sample) {
+	if !a.measured {
+		a.sample = sample
+		a.measured = true
+		return
+	}
+	worse := worsening(a.config, a.sample, sample)
+	if worse == 0 || (a.temperature > 0 && a.rng.Float64() < math.Exp(-worse/a.temperature)) {
+		a.current, a.sample = point, sample
+	}
+	a.temperature *= a.cooling
+}
+
+/*
+applyPoint sets the dimensions that differ between from and to, through reconfigure so
+every change is recorded, and settles once after the last one. It returns the point
+actually set, which differs from to when a dimension fails.
+*/
+func applyPoint(ethHandle *ethtool.Ethtool, config Config, space []Dimension, from []int, to []int) (Config, []int, error) {
+	applied := slices.Clone(from)
+	var changed []int
+	for i := range space {
+		if from[i] != to[i] {
+			changed = append(changed, i)
+		}
+	}
+	var names []string
+	for _, i := range changed {
+		names = append(names, space[i].Name)
+	}
+	for n, i := range changed {
+		d := space[i]
+		old := "unknown"
+		if from[i] >= 0 {
+			old = d.Values[from[i]]
+		}
+		settle := func(Config) {}
+		if n == len(changed)-1 {
+			settle = func(config Config) { settleKnob(ethHandle, config, names...) }
+		}
+		next, err := reconfigure(ethHandle, config, d.Name, old, d.Values[to[i]], func() (Config, error) {
+			return d.Apply(config, to[i])
+		}, settle)
+		if err != nil {
+			applied[i] = -1
+			return config, applied, fmt.Errorf("setting %s to %s: %w", d.Name, d.Values[to[i]], err)
+		}
+		config = next
+		applied[i] = to[i]
+	}
+	return config, applied, nil
+}
+
+/*
+jointSearch keeps a strategy over the joint space of the enabled knobs across rounds,
+so a grid sweep resumes where it stopped and a model keeps what it learned.
+*/
+type jointSearch struct {
+	space    []Dimension
+	start    []int
+	applied  []int // point set now, -1 where unknown
+	strategy SearchStrategy
+}
+
+func newJointSearch(config Config, space []Dimension) *jointSearch {
+	j := &jointSearch{space: space, applied: make([]int, len(space)), start: make([]int, len(space))}
+	for i, d := range space {
+		j.applied[i] = d.Current
+		// un valore fuori lista si sostituisce col primo
+		j.start[i] = d.nearest()
+	}
+	if len(space) > 0 {
+		j.strategy = newSearchStrategy(config, space, j.start)
+	}
+	return j
+}
+
+/*
+round runs the strategy for at most MaxEvaluations configurations, writing a CSV row
+for each, and leaves the best of the round set. A strategy with nothing left to try
+starts over from the point set now.
+*/
+func (j *jointSearch) round(ethHandle *ethtool.Ethtool, config Config, interval int, writer *csv.Writer) (Config, Sample) {
+	p := message.NewPrinter(language.English)
+	if j.strategy == nil {
+		return config, measure(ethHandle, config, interval)
+	}
+	space := j.space
+
+	var best Sample
+	var bestPoint []int
+	for evaluation := range max(config.Search.MaxEvaluations, 1) {
+		if stopping() {
+			break
+		}
+		point := j.strategy.Next()
+		if point == nil {
+			p.Printf("%s search done after %d configurations this round\n", j.strategy.Name(), evaluation)
+			if evaluation > 0 {
+				break
+			}
+			// ricomincia dal punto attuale, l'ottimo puo' essersi spostato col traffico
+			for i := range j.start {
+				j.start[i] = max(j.applied[i], 0)
+			}
+			j.strategy = newSearchStrategy(config, space, j.start)
+			if point = j.strategy.Next(); point == nil {
+				break
+			}
+		}
+		next, set, err := applyPoint(ethHandle, config, space, j.applied, point)
+		config, j.applied = next, set
+		if err != nil {
+			p.Printf("%s, skipping %s\n", err, pointString(space, point))
+			j.strategy.Observe(point, Sample{Invalid: err.Error()})
+			continue
+		}
+		sample, _ := measureSamples(ethHandle, config, interval, config.Significance.SubWindows)
+		writeCSV(writer, config, sample)
+		j.strategy.Observe(point, sample)
+		if bestPoint == nil || better(config, sample, best) {
+			best, bestPoint = sample, point
+		}
+	}
+	if bestPoint == nil {
+		return config, measure(ethHandle, config, interval)
+	}
+
+	p.Printf("Best %s configuration: %s (%s=%f)\n", j.strategy.Name(), pointString(space, bestPoint), objective.Name(), objective.Cost(best))
+	if predictor, ok := j.strategy.(optimumPredictor); ok {
+		if point, cost, pFeasible, ok := predictor.Predicted(); ok {
+			p.Printf("Predicted optimum: %s (%s=%f, feasible with probability %f)\n", pointString(space, point), objective.Name(), cost, pFeasible)
+		}
+	}
+	config, applied, err := applyPoint(ethHandle, config, space, j.applied, bestPoint)
+	j.applied = applied
+	if err != nil {
+		panic(err.Error())
+	}
+	return config, best
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGridSearch(t *testing.T) {
+	space := []Dimension{
+		{Name: "rxqueue", Values: []string{"512", "1024", "2048"}},
+		{Name: "budget", Values: []string{"64", "300"}},
+	}
+	// 2048 resta fuori dall'intervallo permesso
+	config := Config{Constraints: Constraints{Ranges: map[string][2]float64{"rxqueue": {0, 1024}}}}
+	g := &gridSearch{config: config, space: space}
+	var got [][]int
+	for point := g.Next(); point != nil; point = g.Next() {
+		got = append(got, point)
+	}
+	want := [][]int{{0, 0}, {0, 1}, {1, 0}, {1, 1}}
+	if !slices.EqualFunc(got, want, slices.Equal[[]int]) {
+		t.Errorf("grid points = %v, want %v", got, want)
+	}
+}
+
+func TestAllowedPoint(t *testing.T) {
+	space := []Dimension{
+		{Name: "rxqueue", Values: []string{"512", "1024", "4096"}},
+		{Name: "ddio", Values: []string{"400", "600", "7ff"}, Base: 16},
+		{Name: "governor", Values: []string{"performance", "powersave"}},
+	}
+	config := Config{Constraints: Constraints{Ranges: map[string][2]float64{
+		"rxqueue":  {512, 2048},
+		"ddio":     {0x400, 0x600}, // sul numero, "7ff" non si legge in decimale
+		"governor": {0, 1},         // non sono numeri, sempre permessi
+	}}}
+	tests := []struct {
+		point []int
+		want  bool
+	}{
+		{[]int{0, 0, 0}, true},
+		{[]int{1, 1, 1}, true},
+		{[]int{2, 0, 0}, false},
+		{[]int{0, 2, 0}, false},
+	}
+	for _, test := range tests {
+		if got := allowedPoint(config, space, test.point); got != test.want {
+			t.Errorf("allowedPoint(%s) = %t, want %t", pointString(space, test.point), got, test.want)
+		}
+	}
+	if got, want := pointString(space, []int{1, 2, 0}), "rxqueue=1024;ddio=7ff;governor=performance"; got != want {
+		t.Errorf("pointString = %q, want %q", got, want)
+	}
+}
+
+func TestBetter(t *testing.T) {
+	config := Config{Constraints: Constraints{MaxCoreCPU: 90}}
+	cheap := Sample{PPS: 1000, InputPPS: 1000, CPU: 30, MaxCoreCPU: 40}
+	costly := Sample{PPS: 1000, InputPPS: 1000, CPU: 60, MaxCoreCPU: 70}
+	saturated := Sample{PPS: 900, InputPPS: 1000, NotProcessed: 100, CPU: 95, MaxCoreCPU: 99}
+	worse := Sample{PPS: 500, InputPPS: 1000, NotProcessed: 500, CPU: 95, MaxCoreCPU: 99}
+	tests := []struct {
+		name string
+		a, b Sample
+		want bool
+	}{
+		{"lower cost", cheap, costly, true},
+		{"higher cost", costly, cheap, false},
+		// una configurazione fattibile batte sempre una che non lo è
+		{"feasible first", costly, saturated, true},
+		{"infeasible last", saturated, costly, false},
+		// tra due non fattibili conta la frazione processata
+		{"more processed", saturated, worse, true},
+		{"invalid", Sample{Invalid: "reset"}, costly, false},
+	}
+	for _, test := range tests {
+		if got := better(config, test.a, test.b); got != test.want {
+			t.Errorf("%s: better = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
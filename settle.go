@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+/*
+SettleConfig is how long to wait after a knob changes before measuring, so the transient
+of a reconfiguration (setConfig recreates queues and NAPI contexts) is not taken for the
+steady state. A knob in Delays waits that long, the others wait for the action rate to
+stabilize when Auto is set.
+*/
+type SettleConfig struct {
+	Delays    map[string]time.Duration
+	Auto      bool
+	Step      time.Duration // window of every rate reading while waiting
+	Tolerance float64       // relative change between two readings that counts as stable
+	MaxWait   time.Duration
+}
+
+// settleKnob waits until the knobs just changed can be measured, the longest delay wins
+func settleKnob(ethHandle *ethtool.Ethtool, config Config, knobs ...string) {
+	conf := config.Settle
+	var delay time.Duration
+	found := false
+	for _, knob := range knobs {
+		if d, ok := conf.Delays[knob]; ok {
+			delay = max(delay, d)
+			found = true
+		}
+	}
+	if found {
+		time.Sleep(delay)
+		return
+	}
+	if !conf.Auto || conf.Step <= 0 {
+		return
+	}
+
+	start := time.Now()
+	last := measureFor(ethHandle, config, conf.Step).PPS
+	for time.Since(start) < conf.MaxWait {
+		rate := measureFor(ethHandle, config, conf.Step).PPS
+		if math.Abs(float64(rate)-float64(last)) <= conf.Tolerance*math.Max(float64(last), 1) {
+			return
+		}
+		last = rate
+	}
+	p := message.NewPrinter(language.English)
+	p.Printf("%s not stable after %s, measuring anyway\n", strings.Join(knobs, ", "), conf.MaxWait)
+}
+
+/*
+reconfigure applies a change and settles, counting the packets received but not
+processed meanwhile as the cost of switching the knob from one value to the other.
+*/
+func reconfigure(ethHandle *ethtool.Ethtool, config Config, knob string, from string, to string, apply func() (Config, error), settle func(Config)) (Config, error) {
+	stats, err := ethHandle.Stats(config.Iface)
+	if err != nil {
+		panic(err.Error())
+	}
+	prePhy := stats["rx_packets_phy"]
+	preAction := stats[config.Action]
+	start := time.Now()
+
+	next, err := apply()
+	if err != nil {
+		return config, err
+	}
+	duration := time.Since(start)
+	settle(next)
+
+	stats, err = ethHandle.Stats(config.Iface)
+	if err != nil {
+		panic(err.Error())
+	}
+	totPhy, okPhy := counterDelta(prePhy, stats["rx_packets_phy"])
+	totAction, okAction := counterDelta(preAction, stats[config.Action])
+	if !okPhy || !okAction {
+		// il cambio ha azzerato i contatori, le perdite non si possono contare
+		fmt.Printf("Counters reset switching %s from %s to %s, loss unknown\n", knob, from, to)
+		return next, nil
+	}
+	var lost uint64
+	if totPhy > totAction {
+		lost = totPhy - totAction
+	}
+	recordDisruption(knob, from, to, duration, lost)
+	return next, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	cleanups     []func()
+	shutdownOnce sync.Once
+	// stop is closed on the first SIGINT/SIGTERM, the tuning loop returns at the next step
+	stop = make(chan struct{})
+)
+
+// atShutdown registers a cleanup, they run in reverse order like defers
+func atShutdown(cleanup func()) {
+	cleanups = append(cleanups, cleanup)
+}
+
+// shutdown puts back everything the tuner changed, only the first call does anything
+func shutdown() {
+	shutdownOnce.Do(func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			runCleanup(cleanups[i])
+		}
+	})
+}
+
+// runCleanup runs one cleanup, a panic is printed so the ones after it still run
+func runCleanup(cleanup func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Cleanup failed: %v\n", r)
+		}
+	}()
+	cleanup()
+}
+
+// fatalf puts back what was already changed before exiting, log.Fatalf alone skips the cleanups
+func fatalf(format string, args ...any) {
+	log.Printf(format, args...)
+	shutdown()
+	os.Exit(1)
+}
+
+func stopping() bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+handleSignals closes stop on the first SIGINT/SIGTERM so main returns and shuts down
+after the step in progress, a second signal shuts down and exits right away.
+*/
+func handleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Printf("Stopping after the current step, signal again to stop now\n")
+		close(stop)
+		<-signals
+		shutdown()
+		os.Exit(1)
+	}()
+}
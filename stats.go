@@ -0,0 +1,288 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+type SignificanceConfig struct {
+	SubWindows int     // sub-windows each candidate is measured over, 1 or less disables the test
+	Alpha      float64 // significance level a change must reach to be accepted
+}
+
+/*
+WindowConfig makes the measurement length adaptive: sub-windows are added until the
+confidence interval on the objective is within RelWidth of its mean, after at least
+MinTime and at most MaxTime. Stable traffic is measured quickly, noisy traffic longer.
+*/
+type WindowConfig struct {
+	Adaptive   bool
+	MinTime    time.Duration
+	MaxTime    time.Duration
+	Confidence float64 // two-sided level of the interval, e.g. 0.95
+	RelWidth   float64 // half width allowed, relative to the mean
+}
+
+// measureSamples splits the interval in sub-windows and returns their average and every one of them
+func measureSamples(ethHandle *ethtool.Ethtool, config Config, interval int, subWindows int) (Sample, []Sample) {
+	subWindows = max(subWindows, 1)
+	window := time.Duration(interval) * time.Second / time.Duration(subWindows)
+	if config.Window.Adaptive {
+		return measureAdaptive(ethHandle, config, window)
+	}
+	var samples []Sample
+	for range subWindows {
+		samples = append(samples, measureFor(ethHandle, config, window))
+	}
+	return aggregate(samples), samples
+}
+
+/*
+measureAdaptive measures sub-windows of the given length until the confidence interval
+on the objective cost is tight enough or MaxTime is reached. Windows with a non finite
+cost, e.g. no input traffic, do not count, and when none counted by MinTime the link is
+idle and the measurement stops there.
+*/
+func measureAdaptive(ethHandle *ethtool.Ethtool, config Config, window time.Duration) (Sample, []Sample) {
+	p := message.NewPrinter(language.English)
+	conf := config.Window
+	var samples []Sample
+	var costs []float64
+	start := time.Now()
+	for {
+		sample := measureFor(ethHandle, config, window)
+		samples = append(samples, sample)
+		if cost := objective.Cost(sample); !math.IsInf(cost, 0) && !math.IsNaN(cost) {
+			costs = append(costs, cost)
+		}
+		elapsed := time.Since(start)
+		if elapsed < conf.MinTime {
+			continue
+		}
+		// senza traffico l'intervallo non si stringe mai, una sola finestra vuota invece non basta
+		if len(costs) == 0 {
+			p.Printf("%s not converged after %d windows: no finite cost, is the link idle?\n", objective.Name(), len(samples))
+			break
+		}
+		if len(costs) < 2 {
+			if elapsed+window > conf.MaxTime {
+				p.Printf("%s not converged after %d windows: %f from a single window\n", objective.Name(), len(samples), costs[0])
+				break
+			}
+			continue
+		}
+		m := mean(costs)
+		n := float64(len(costs))
+		halfWidth := studentQuantile(1-(1-conf.Confidence)/2, n-1) * math.Sqrt(variance(costs)/n)
+		if halfWidth <= conf.RelWidth*math.Abs(m) {
+			break
+		}
+		if elapsed+window > conf.MaxTime {
+			p.Printf("%s not converged after %d windows: %f +- %f\n", objective.Name(), len(costs), m, halfWidth)
+			break
+		}
+	}
+	return aggregate(samples), samples
+}
+
+// aggregate averages the samples, core by core for the per core usage
+func aggregate(samples []Sample) Sample {
+	if len(samples) == 1 {
+		return samples[0]
+	}
+	var s Sample
+	n := float64(len(samples))
+	var pps, input, notProcessed float64
+	s.Loss = make(map[string]uint64)
+	for _, sample := range samples {
+		if sample.Invalid != "" {
+			s.Invalid = sample.Invalid
+		}
+		for cause, rate := range sample.Loss {
+			s.Loss[cause] += uint64(float64(rate) / n)
+		}
+		pps += float64(sample.PPS)
+		input += float64(sample.InputPPS)
+		notProcessed += float64(sample.NotProcessed)
+		s.CPU += sample.CPU / n
+		s.MaxCoreCPU = max(s.MaxCoreCPU, sample.MaxCoreCPU)
+		s.Watts += sample.Watts / n
+		s.Energy += sample.Energy / n
+		s.LLCOccupancy += uint64(float64(sample.LLCOccupancy) / n)
+		s.MemBandwidth += sample.MemBandwidth / n
+		if s.CoreCPU == nil {
+			s.CoreCPU = make([]float64, len(sample.CoreCPU))
+			s.CoreQueues = sample.CoreQueues
+		}
+		for i := range min(len(s.CoreCPU), len(sample.CoreCPU)) {
+			s.CoreCPU[i] += sample.CoreCPU[i] / n
+		}
+	}
+	s.PPS = uint64(pps / n)
+	s.InputPPS = uint64(input / n)
+	s.NotProcessed = int(math.Round(notProcessed / n))
+	return s
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// variance returns the sample variance
+func variance(values []float64) float64 {
+	m := mean(values)
+	var sum float64
+	for _, v := range values {
+		sum += (v - m) * (v - m)
+	}
+	return sum / float64(len(values)-1)
+}
+
+/*
+welchTest checks whether the values in b are lower than the ones in a, with unequal
+variances. It returns the one-sided p-value and the effect size as Cohen's d, positive
+when b is lower. With fewer than two values per side there is nothing to test and the
+p-value is 0, so the caller decides as without the test.
+*/
+func welchTest(a []float64, b []float64) (float64, float64) {
+	if len(a) < 2 || len(b) < 2 {
+		return 0, 0
+	}
+	meanA, meanB := mean(a), mean(b)
+	varA, varB := variance(a), variance(b)
+	var effect float64
+	if sd := math.Sqrt((varA + varB) / 2); sd > 0 {
+		effect = (meanA - meanB) / sd
+	}
+
+	seA, seB := varA/float64(len(a)), varB/float64(len(b))
+	se := math.Sqrt(seA + seB)
+	if se == 0 {
+		// nessuna varianza, conta solo il segno
+		if meanB < meanA {
+			return 0, effect
+		}
+		return 1, effect
+	}
+	t := (meanB - meanA) / se
+	df := (seA + seB) * (seA + seB) / (seA*seA/float64(len(a)-1) + seB*seB/float64(len(b)-1))
+	p := studentCDF(t, df)
+	if math.IsNaN(p) {
+		return 1, effect
+	}
+	return p, effect
+}
+
+/*
+pairedTest checks whether b is lower than a on paired values, a[i] and b[i] measured
+back to back. It returns the one-sided p-value and the effect size as the mean
+difference over its standard deviation, positive when b is lower.
+*/
+func pairedTest(a []float64, b []float64) (float64, float64) {
+	n := min(len(a), len(b))
+	if n < 2 {
+		return 0, 0
+	}
+	var diffs []float64
+	for i := range n {
+		diffs = append(diffs, b[i]-a[i])
+	}
+	m := mean(diffs)
+	sd := math.Sqrt(variance(diffs))
+	if sd == 0 {
+		if m < 0 {
+			return 0, 0
+		}
+		return 1, 0
+	}
+	p := studentCDF(m/(sd/math.Sqrt(float64(n))), float64(n-1))
+	if math.IsNaN(p) {
+		return 1, -m / sd
+	}
+	return p, -m / sd
+}
+
+// studentCDF returns P(T <= t) for a Student t distribution with df degrees of freedom
+func studentCDF(t float64, df float64) float64 {
+	x := df / (df + t*t)
+	tail := 0.5 * incompleteBeta(df/2, 0.5, x)
+	if t > 0 {
+		return 1 - tail
+	}
+	return tail
+}
+
+// studentQuantile inverts studentCDF by bisection
+func studentQuantile(p float64, df float64) float64 {
+	low, high := -1e3, 1e3
+	for range 100 {
+		mid := (low + high) / 2
+		if studentCDF(mid, df) < p {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return (low + high) / 2
+}
+
+// incompleteBeta is the regularized incomplete beta function I_x(a, b)
+func incompleteBeta(a float64, b float64, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lgab, _ := math.Lgamma(a + b)
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	// la frazione continua converge in fretta solo da questo lato
+	if x < (a+1)/(a+b+2) {
+		return front * betaFraction(a, b, x) / a
+	}
+	return 1 - front*betaFraction(b, a, 1-x)/b
+}
+
+// betaFraction evaluates the continued fraction of the incomplete beta with Lentz's method
+func betaFraction(a float64, b float64, x float64) float64 {
+	const tiny = 1e-300
+	c := 1.0
+	d := 1 - (a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= 200; m++ {
+		fm := float64(m)
+		for _, num := range []float64{
+			fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1)),
+		} {
+			d = 1 + num*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + num/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+		}
+		if math.Abs(d*c-1) < 1e-12 {
+			break
+		}
+	}
+	return h
+}
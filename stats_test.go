@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a float64, b float64, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestIncompleteBeta(t *testing.T) {
+	tests := []struct {
+		a, b, x float64
+		want    float64
+	}{
+		{1, 1, 0.3, 0.3},    // uniforme
+		{3, 1, 0.5, 0.125},  // x^a
+		{4, 4, 0.5, 0.5},    // simmetrica
+		{2, 3, 0.4, 0.5248}, // 1 - (1-x)^4 - 4x(1-x)^3
+		{2, 3, 0, 0},
+		{2, 3, 1, 1},
+	}
+	for _, test := range tests {
+		if got := incompleteBeta(test.a, test.b, test.x); !near(got, test.want, 1e-9) {
+			t.Errorf("incompleteBeta(%g, %g, %g) = %g, want %g", test.a, test.b, test.x, got, test.want)
+		}
+	}
+}
+
+func TestWelchTest(t *testing.T) {
+	tests := []struct {
+		name      string
+		a, b      []float64
+		p, effect float64
+	}{
+		// t = -2 con 8 gradi di libertà
+		{"lower", []float64{3, 4, 5, 6, 7}, []float64{1, 2, 3, 4, 5}, 0.040258, 2 / math.Sqrt(2.5)},
+		{"higher", []float64{1, 2, 3, 4, 5}, []float64{3, 4, 5, 6, 7}, 0.959742, -2 / math.Sqrt(2.5)},
+		{"equal", []float64{1, 2, 3}, []float64{1, 2, 3}, 0.5, 0},
+		// senza varianza conta solo il segno
+		{"zero variance lower", []float64{5, 5, 5}, []float64{4, 4, 4}, 0, 0},
+		{"zero variance higher", []float64{4, 4, 4}, []float64{5, 5, 5}, 1, 0},
+		{"zero variance equal", []float64{4, 4}, []float64{4, 4}, 1, 0},
+		// con meno di due valori non c'è niente da testare
+		{"single a", []float64{5}, []float64{1, 2, 3}, 0, 0},
+		{"single b", []float64{1, 2, 3}, []float64{5}, 0, 0},
+		{"empty", nil, nil, 0, 0},
+	}
+	for _, test := range tests {
+		p, effect := welchTest(test.a, test.b)
+		if !near(p, test.p, 1e-6) || !near(effect, test.effect, 1e-9) {
+			t.Errorf("%s: welchTest(%v, %v) = %g, %g, want %g, %g", test.name, test.a, test.b, p, effect, test.p, test.effect)
+		}
+	}
+}
+
+func TestPairedTest(t *testing.T) {
+	tests := []struct {
+		name      string
+		a, b      []float64
+		p, effect float64
+	}{
+		// differenze -1 -2 -2 -1, t = -5.196 con 3 gradi di libertà
+		{"lower", []float64{10, 12, 14, 16}, []float64{9, 10, 12, 15}, 0.006923, 1.5 / math.Sqrt(1.0/3)},
+		{"higher", []float64{9, 10, 12, 15}, []float64{10, 12, 14, 16}, 0.993077, -1.5 / math.Sqrt(1.0/3)},
+		// stessa differenza ovunque: conta solo il segno
+		{"constant lower", []float64{5, 6, 7}, []float64{4, 5, 6}, 0, 0},
+		{"constant higher", []float64{4, 5, 6}, []float64{5, 6, 7}, 1, 0},
+		{"identical", []float64{4, 5, 6}, []float64{4, 5, 6}, 1, 0},
+		// le coppie sono quante il lato più corto
+		{"single pair", []float64{5, 6, 7}, []float64{1}, 0, 0},
+		{"empty", nil, nil, 0, 0},
+	}
+	for _, test := range tests {
+		p, effect := pairedTest(test.a, test.b)
+		if !near(p, test.p, 1e-6) || !near(effect, test.effect, 1e-9) {
+			t.Errorf("%s: pairedTest(%v, %v) = %g, %g, want %g, %g", test.name, test.a, test.b, p, effect, test.p, test.effect)
+		}
+	}
+}
+
+func TestStudentQuantile(t *testing.T) {
+	tests := []struct {
+		p, df float64
+		want  float64
+	}{
+		{0.975, 1, 12.706205},
+		{0.975, 10, 2.228139},
+		{0.95, 4, 2.131847},
+		{0.5, 7, 0},
+		// simmetrica
+		{0.025, 10, -2.228139},
+		// con tanti gradi di libertà è la normale
+		{0.975, 1e6, 1.959966},
+	}
+	for _, test := range tests {
+		if got := studentQuantile(test.p, test.df); !near(got, test.want, 1e-5) {
+			t.Errorf("studentQuantile(%g, %g) = %g, want %g", test.p, test.df, got, test.want)
+		}
+		if got := studentCDF(test.want, test.df); !near(got, test.p, 1e-6) {
+			t.Errorf("studentCDF(%g, %g) = %g, want %g", test.want, test.df, got, test.p)
+		}
+	}
+}
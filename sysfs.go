@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/VladimiroPaschali/ethtool-indir"
+)
+
+// SysfsKnob is a tunable written with the same value to several sysfs attributes
+type SysfsKnob struct {
+	Name   string
+	Files  []string
+	Values []string // allowed values, in sweep order
+}
+
+// sysfsOriginals keeps the value every attribute had before tuning, restored on exit
+var sysfsOriginals = make(map[string]string)
+
+func readSysfs(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func setSysfsKnob(k SysfsKnob, value string) error {
+	for _, file := range k.Files {
+		if err := os.WriteFile(file, []byte(value), 0644); err != nil {
+			return fmt.Errorf("writing %s to %s: %w", value, file, err)
+		}
+	}
+	return nil
+}
+
+// sortValues orders numeric values, leaves the others as they are
+func sortValues(values []string) {
+	slices.SortStableFunc(values, func(a, b string) int {
+		x, errA := strconv.ParseUint(a, 10, 64)
+		y, errB := strconv.ParseUint(b, 10, 64)
+		if errA != nil || errB != nil {
+			return 0
+		}
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+		return 0
+	})
+}
+
+/*
+initSysfsKnobs records the original value of every attribute and starts each knob from
+the value of its first file, adding it to the allowed values when missing. Knobs whose
+files cannot be read are disabled.
+*/
+func initSysfsKnobs(config Config) Config {
+	config.SysfsValues = make(map[string]string)
+	var knobs []SysfsKnob
+	for _, knob := range config.SysfsKnobs {
+		var value string
+		var err error
+		for _, file := range knob.Files {
+			var original string
+			original, err = readSysfs(file)
+			if err != nil {
+				break
+			}
+			sysfsOriginals[file] = original
+			if value == "" {
+				value = original
+			}
+		}
+		if err != nil || len(knob.Files) == 0 || len(knob.Values) == 0 {
+			fmt.Printf("Knob %s not available (%v), disabled\n", knob.Name, err)
+			continue
+		}
+		if !slices.Contains(knob.Values, value) {
+			knob.Values = append(slices.Clone(knob.Values), value)
+			sortValues(knob.Values)
+		}
+		fmt.Printf("Knob %s is %s\n", knob.Name, value)
+		config.SysfsValues[knob.Name] = value
+		knobs = append(knobs, knob)
+	}
+	config.SysfsKnobs = knobs
+	return config
+}
+
+// restoreSysfs writes back the values found at startup
+func restoreSysfs() {
+	for file, value := range sysfsOriginals {
+		if err := os.WriteFile(file, []byte(value), 0644); err != nil {
+			fmt.Printf("Restoring %s: %s\n", file, err)
+		}
+	}
+}
+
+func sysfsString(config Config) string {
+	var values []string
+	for _, knob := range config.SysfsKnobs {
+		values = append(values, fmt.Sprintf("%s=%s", knob.Name, config.SysfsValues[knob.Name]))
+	}
+	return strings.Join(values, ";")
+}
+
+// sysfsDimension is a sysfs knob as searched
+func sysfsDimension(config Config, knob SysfsKnob) Dimension {
+	values := knob.Values
+
+	return Dimension{knob.Name, values, slices.Index(values, config.SysfsValues[knob.Name]), config.SysfsValues[knob.Name], 10, func(config Config, index int) (Config, error) {
+		if err := setSysfsKnob(knob, values[index]); err != nil {
+			return config, err
+		}
+		config.SysfsValues = maps.Clone(config.SysfsValues)
+		config.SysfsValues[knob.Name] = values[index]
+		return config, nil
+	}}
+}
+
+/*
+test and update a sysfs knob, trying the values next to the current one. A value the
+kernel refuses (e.g. a min frequency above the max) is skipped.
+*/
+func changeSysfs(ethHandle *ethtool.Ethtool, config Config, knob SysfsKnob, interval int, extDrop uint64) (Config, Sample) {
+	config, sample, err := searchNeighbours(ethHandle, config, interval, extDrop, sysfsDimension(config, knob))
+	if err != nil {
+		panic(err.Error())
+	}
+	return config, sample
+}